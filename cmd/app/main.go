@@ -10,9 +10,14 @@ import (
 	"github.com/antnmxmv/booking-service/internal/booking"
 	"github.com/antnmxmv/booking-service/internal/booking/jobs"
 	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/idempotency"
+	"github.com/antnmxmv/booking-service/internal/money"
 	"github.com/antnmxmv/booking-service/internal/payment"
 	"github.com/antnmxmv/booking-service/internal/price"
+	"github.com/antnmxmv/booking-service/internal/storage"
+	"github.com/antnmxmv/booking-service/internal/storage/bolt"
 	"github.com/antnmxmv/booking-service/internal/storage/inmemory"
+	"github.com/antnmxmv/booking-service/internal/storage/postgres"
 	"github.com/antnmxmv/booking-service/pkg/queue"
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxevent"
@@ -28,7 +33,17 @@ func createApp() *fx.App {
 		}),
 
 		fx.Provide(
-			inmemory.NewStorage().WithRoomAvailability(data.RoomAvailability).Build,
+			// repository backend is chosen by storage.type from config
+			func(cnf *config.Config, p *payment.Provider) *storage.Repository {
+				return storage.NewRepository(cnf, map[string]storage.Backend{
+					storage.InMemoryType: inmemory.NewStorage().WithRoomAvailability(data.RoomAvailability).WithJournal(cnf, p),
+					storage.PostgresType: postgres.NewStorage(cnf, p).WithRoomAvailability(data.RoomAvailability),
+					storage.BoltType:     bolt.NewStorage(cnf, p).WithRoomAvailability(data.RoomAvailability),
+				})
+			},
+			func(r *storage.Repository) booking.Repository { return r },
+			func(r *storage.Repository) idempotency.Store { return r },
+			idempotency.NewCleaner,
 
 			func() handlers.ReadinessMonitor {
 				return isReady
@@ -43,22 +58,62 @@ func createApp() *fx.App {
 				return payment.NewPaymentProvider(cash, card)
 			},
 
+			func(cnf *config.Config) *queue.PersistentDelayedQueue[jobs.CaptureMessage] {
+				return queue.NewPersistentDelayedQueue[jobs.CaptureMessage](func() queue.PersistentOptions {
+					return queue.PersistentOptions{
+						Path:       cnf.Payment.CaptureQueue.Path,
+						AckTimeout: cnf.Payment.CaptureQueue.AckTimeout,
+					}
+				})
+			},
+			func(q *queue.PersistentDelayedQueue[jobs.CaptureMessage]) jobs.CaptureQueue { return q },
+			func(cnf *config.Config) *queue.PersistentDelayedQueue[jobs.InstallmentMessage] {
+				return queue.NewPersistentDelayedQueue[jobs.InstallmentMessage](func() queue.PersistentOptions {
+					return queue.PersistentOptions{
+						Path:       cnf.Payment.InstallmentQueue.Path,
+						AckTimeout: cnf.Payment.InstallmentQueue.AckTimeout,
+					}
+				})
+			},
+			func(q *queue.PersistentDelayedQueue[jobs.InstallmentMessage]) jobs.InstallmentQueue { return q },
 			jobs.NewPaymentJob,
 
+			money.NewStaticRateProvider,
+			func(r *money.StaticRateProvider) money.RateProvider { return r },
 			price.NewExampleProvider,
 			AsReservationJob(jobs.NewPriceJob, `name:"price-job"`),
 
 			AsReservationJob(func(j *jobs.PaymentJob) booking.Job { return j }, `name:"payment-job"`),
 			AsReservationJob(jobs.NewNotificationJob, `name:"notification-job"`),
 
+			func(cnf *config.Config) booking.PlanResolver { return booking.NewConfigPlanResolver(cnf) },
 			fx.Annotate(
 				booking.NewReservationOrchestrator,
 				fx.ParamTags(
+					`name:""`,
+					`name:""`,
 					`name:""`,
 					`group:"reservation-jobs"`,
 				),
 			),
-			fx.Annotate(queue.NewDelayedQueue[string], fx.As(new(booking.DelayedQueue))),
+			func(cnf *config.Config) *queue.PersistentDelayedQueue[string] {
+				return queue.NewPersistentDelayedQueue[string](func() queue.PersistentOptions {
+					return queue.PersistentOptions{
+						Path:       cnf.Booking.CancelationQueue.Path,
+						AckTimeout: cnf.Booking.CancelationQueue.AckTimeout,
+					}
+				})
+			},
+			func(q *queue.PersistentDelayedQueue[string]) booking.DelayedQueue { return q },
+			func(cnf *config.Config) *queue.PersistentDelayedQueue[booking.StepDeadline] {
+				return queue.NewPersistentDelayedQueue[booking.StepDeadline](func() queue.PersistentOptions {
+					return queue.PersistentOptions{
+						Path:       cnf.Booking.DeadlineQueue.Path,
+						AckTimeout: cnf.Booking.DeadlineQueue.AckTimeout,
+					}
+				})
+			},
+			func(q *queue.PersistentDelayedQueue[booking.StepDeadline]) booking.DeadlineQueue { return q },
 
 			booking.NewBookingService,
 
@@ -68,6 +123,13 @@ func createApp() *fx.App {
 
 		fx.Invoke(
 			AsHook[*config.Loader],
+			AsHook[*money.StaticRateProvider],
+			AsHook[*storage.Repository],
+			AsHook[*idempotency.Cleaner],
+			AsHook[*queue.PersistentDelayedQueue[string]],
+			AsHook[*queue.PersistentDelayedQueue[booking.StepDeadline]],
+			AsHook[*queue.PersistentDelayedQueue[jobs.CaptureMessage]],
+			AsHook[*queue.PersistentDelayedQueue[jobs.InstallmentMessage]],
 			AsHook[*payment.CardSource],
 			AsHook[*payment.Provider],
 			AsHook[*jobs.PaymentJob],
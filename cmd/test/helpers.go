@@ -9,6 +9,7 @@ import (
 	"github.com/antnmxmv/booking-service/internal/booking"
 	"github.com/antnmxmv/booking-service/internal/booking/jobs"
 	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
 	"github.com/antnmxmv/booking-service/internal/payment"
 	"github.com/antnmxmv/booking-service/internal/price"
 	"github.com/antnmxmv/booking-service/internal/storage/inmemory"
@@ -57,11 +58,17 @@ func runTestingApp() *container.App {
 	paymentProvider := payment.NewPaymentProvider(cardPaymentSource, payment.NewCashSource())
 	app.AddContainer(paymentProvider)
 
+	// no rates file, so costs are shown in base currency only
+	rates := money.NewStaticRateProvider(config.Config)
+	app.AddContainer(rates)
+
 	repository := inmemory.NewStorage().WithRoomAvailability(data.RoomAvailability)
 	// building reservation strategy
 	reservationOrchestrator := booking.NewReservationOrchestrator(
 		repository,
-		jobs.NewPriceJob(price.NewExampleProvider()),
+		queue.NewDelayedQueue[booking.StepDeadline](),
+		nil,
+		jobs.NewPriceJob(price.NewExampleProvider(config.Config), rates),
 		// without payment job
 		jobs.NewNotificationJob(),
 	)
@@ -73,7 +80,7 @@ func runTestingApp() *container.App {
 	)
 	app.AddContainer(bookingService)
 
-	controller := api.NewController(config.Config, bookingService, paymentProvider, app.IsReady, middlewares.NewPrometheus(config.Config))
+	controller := api.NewController(config.Config, bookingService, paymentProvider, repository, app.IsReady, middlewares.NewPrometheus(config.Config), rates)
 	app.AddContainer(controller)
 
 	go app.Run()
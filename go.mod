@@ -3,8 +3,11 @@ module github.com/antnmxmv/booking-service
 go 1.19
 
 require (
+	github.com/fergusstrange/embedded-postgres v1.34.0
 	github.com/gin-gonic/gin v1.9.1
+	github.com/lib/pq v1.10.9
 	github.com/prometheus/client_golang v1.19.0
+	go.etcd.io/bbolt v1.3.9
 	go.uber.org/fx v1.21.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -32,6 +35,7 @@ require (
 	github.com/prometheus/procfs v0.12.0 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.11 // indirect
+	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
 	go.uber.org/dig v1.17.1 // indirect
 	go.uber.org/multierr v1.10.0 // indirect
 	go.uber.org/zap v1.26.0 // indirect
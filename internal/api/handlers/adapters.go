@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/money"
 	"github.com/antnmxmv/booking-service/internal/payment"
 )
 
@@ -16,23 +17,68 @@ func reservationModelToResponse(r *booking.Reservation) reservationResponse {
 		StartDate:             newTimeJSON(r.StartDate),
 		EndDate:               newTimeJSON(r.EndDate),
 		Cost:                  r.Cost,
-		Status:                reservationStatusToResponse(r.Status),
+		Status:                reservationStatusToResponse(r),
+		Step:                  string(r.Status),
 		AppliedDiscountIDs:    r.AppliedDiscountIDs,
 		LastUpdateTime:        newTimeJSON(r.LastUpdateTime),
+		Failure:               reservationFailureToResponse(r.Failure),
+		Installments:          r.Installments,
+		DisplayCost:           r.DisplayCost,
+	}
+}
+
+func reservationFailureToResponse(f *booking.ReservationFailure) *reservationFailureResponse {
+	if f == nil {
+		return nil
+	}
+	return &reservationFailureResponse{
+		Step:   string(f.Step),
+		Action: string(f.Action),
+		Reason: f.Reason,
+		Time:   newTimeJSON(f.Time),
 	}
 }
 
 type reservationResponse struct {
-	ID                    string               `json:"id"`
-	HotelID               string               `json:"hotel_id"`
-	RoomTypes             booking.RoomsRequest `json:"rooms"`
-	PaymentType           payment.SourceType   `json:"payment_type"`
-	PaymentOrder          payment.Order        `json:"payment_order,omitempty"`
-	PaymentRequestDetails payment.OrderDetails `json:"payment_request,omitempty"`
-	StartDate             *TimeJSON            `json:"start_date"`
-	EndDate               *TimeJSON            `json:"end_date"`
-	Cost                  int                  `json:"cost"`
-	Status                string               `json:"status"`
-	AppliedDiscountIDs    []string             `json:"applied_discount_ids"`
-	LastUpdateTime        *TimeJSON            `json:"last_update"`
+	ID                    string                      `json:"id"`
+	HotelID               string                      `json:"hotel_id"`
+	RoomTypes             booking.RoomsRequest        `json:"rooms"`
+	PaymentType           payment.SourceType          `json:"payment_type"`
+	PaymentOrder          payment.Order               `json:"payment_order,omitempty"`
+	PaymentRequestDetails payment.OrderDetails        `json:"payment_request,omitempty"`
+	StartDate             *TimeJSON                   `json:"start_date"`
+	EndDate               *TimeJSON                   `json:"end_date"`
+	Cost                  money.Money                 `json:"cost"`
+	Status                string                      `json:"status"`
+	Step                  string                      `json:"step"`
+	AppliedDiscountIDs    []string                    `json:"applied_discount_ids"`
+	LastUpdateTime        *TimeJSON                   `json:"last_update"`
+	Failure               *reservationFailureResponse `json:"failure,omitempty"`
+	Installments          []*booking.Installment      `json:"installments,omitempty"`
+	DisplayCost           *money.Money                `json:"display_cost,omitempty"`
+}
+
+type reservationFailureResponse struct {
+	Step   string    `json:"step"`
+	Action string    `json:"action"`
+	Reason string    `json:"reason"`
+	Time   *TimeJSON `json:"time"`
+}
+
+func sagaLogEntryModelToResponse(e *booking.SagaLogEntry) sagaLogEntryResponse {
+	return sagaLogEntryResponse{
+		Step:    string(e.Step),
+		Action:  string(e.Action),
+		Outcome: string(e.Outcome),
+		Error:   e.Error,
+		Time:    newTimeJSON(e.Time),
+	}
+}
+
+type sagaLogEntryResponse struct {
+	Step    string    `json:"step"`
+	Action  string    `json:"action"`
+	Outcome string    `json:"outcome"`
+	Error   string    `json:"error,omitempty"`
+	Time    *TimeJSON `json:"time"`
 }
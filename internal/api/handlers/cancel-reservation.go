@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/gin-gonic/gin"
+)
+
+type cancelReservationHandler struct {
+	s *booking.BookingService
+}
+
+func NewCancelReservationHandler(bookingService *booking.BookingService) gin.HandlerFunc {
+	return (&cancelReservationHandler{s: bookingService}).handlerFn
+}
+
+func (h *cancelReservationHandler) handlerFn(ctx *gin.Context) {
+	setHeaders(ctx)
+
+	res, err := h.s.CancelReservation(ctx.Request.Context(), ctx.GetHeader("user_id"), ctx.Param("id"))
+
+	if err != nil {
+		if errors.Is(err, booking.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, errorJSON(err.Error()))
+		} else if errors.Is(err, booking.ErrNotOwner) {
+			ctx.JSON(http.StatusForbidden, errorJSON(err.Error()))
+		} else if errors.Is(err, booking.ErrAlreadyCanceled) || errors.Is(err, booking.ErrConcurrentModification) {
+			ctx.JSON(http.StatusConflict, errorJSON(err.Error()))
+		} else {
+			ctx.JSON(http.StatusInternalServerError, errorJSON(http.StatusText(http.StatusInternalServerError)))
+		}
+
+		return
+	}
+
+	ctx.JSON(http.StatusOK, reservationModelToResponse(res))
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/payment"
+	"github.com/gin-gonic/gin"
+)
+
+type changePaymentMethodHandler struct {
+	s *booking.BookingService
+	p *payment.Provider
+}
+
+func NewChangePaymentMethodHandler(bookingService *booking.BookingService, paymentProvider *payment.Provider) gin.HandlerFunc {
+	return (&changePaymentMethodHandler{s: bookingService, p: paymentProvider}).handlerFn
+}
+
+// changePaymentMethodRequest object for manual decoding
+type changePaymentMethodRequest struct {
+	PaymentType    string          `json:"payment_type"`
+	PaymentDetails json.RawMessage `json:"payment_details"`
+}
+
+func (h *changePaymentMethodHandler) handlerFn(ctx *gin.Context) {
+	setHeaders(ctx)
+
+	req := changePaymentMethodRequest{}
+	_ = ctx.ShouldBindJSON(&req)
+
+	paymentType := payment.SourceType(req.PaymentType)
+
+	if _, ok := h.p.GetSources()[paymentType]; !ok {
+		ctx.JSON(paymentTypeError.code, errorJSON(paymentTypeError.text))
+		return
+	}
+
+	details, err := h.p.UnmarshalDetailsJSON(paymentType, req.PaymentDetails)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorJSON(err.Error()))
+		return
+	}
+
+	res, err := h.s.ChangePaymentMethod(ctx.Request.Context(), ctx.GetHeader("user_id"), ctx.Param("id"), paymentType, details)
+
+	if err != nil {
+		if errors.Is(err, booking.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, errorJSON(err.Error()))
+		} else if errors.Is(err, booking.ErrNotOwner) {
+			ctx.JSON(http.StatusForbidden, errorJSON(err.Error()))
+		} else if errors.Is(err, booking.ErrAlreadyCanceled) || errors.Is(err, booking.ErrAlreadyFinished) ||
+			errors.Is(err, booking.ErrConcurrentModification) {
+			ctx.JSON(http.StatusConflict, errorJSON(err.Error()))
+		} else {
+			ctx.JSON(http.StatusInternalServerError, errorJSON(http.StatusText(http.StatusInternalServerError)))
+		}
+
+		return
+	}
+
+	ctx.JSON(http.StatusOK, reservationModelToResponse(res))
+}
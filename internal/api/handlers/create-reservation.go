@@ -1,23 +1,28 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"time"
 
 	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
 	"github.com/antnmxmv/booking-service/internal/payment"
 	"github.com/gin-gonic/gin"
 )
 
 type reservationHandler struct {
-	s *booking.BookingService
-	p *payment.Provider
+	cnf   *config.Config
+	s     *booking.BookingService
+	p     *payment.Provider
+	rates money.RateProvider
 }
 
-func NewCreateReservationHandler(bookingService *booking.BookingService, paymentObserver *payment.Provider) gin.HandlerFunc {
-	return (&reservationHandler{s: bookingService, p: paymentObserver}).handlerFn
+func NewCreateReservationHandler(cnf *config.Config, bookingService *booking.BookingService, paymentObserver *payment.Provider, rates money.RateProvider) gin.HandlerFunc {
+	return (&reservationHandler{cnf: cnf, s: bookingService, p: paymentObserver, rates: rates}).handlerFn
 }
 
 func (h *reservationHandler) handlerFn(ctx *gin.Context) {
@@ -36,6 +41,9 @@ func (h *reservationHandler) handlerFn(ctx *gin.Context) {
 	}
 
 	err = h.validate(reservationRequest)
+	if err == nil {
+		err = h.validateDisplayCurrency(ctx.Request.Context(), reservationRequest)
+	}
 
 	if err != nil {
 		httpError := err.(httpError)
@@ -43,7 +51,7 @@ func (h *reservationHandler) handlerFn(ctx *gin.Context) {
 		return
 	}
 
-	res, err := h.s.CreateReservation(userID, reservationRequest)
+	res, err := h.s.CreateReservation(ctx.Request.Context(), userID, reservationRequest)
 
 	if err != nil {
 		if errors.Is(err, booking.ErrAlreadyBooked) || errors.Is(err, booking.ErrDuplicate) {
@@ -51,6 +59,10 @@ func (h *reservationHandler) handlerFn(ctx *gin.Context) {
 		} else if errors.Is(err, booking.ErrNotWorkingDays) ||
 			errors.Is(err, booking.ErrNotListedPaymentType) {
 			ctx.JSON(http.StatusBadRequest, errorJSON(err.Error()))
+		} else if res != nil {
+			// reservation is booked, so retry must get it instead of booking another one
+			ctx.Header("Location", "/reservation/"+res.ID)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError), "id": res.ID})
 		} else {
 			ctx.JSON(http.StatusInternalServerError, errorJSON(http.StatusText(http.StatusInternalServerError)))
 		}
@@ -58,6 +70,7 @@ func (h *reservationHandler) handlerFn(ctx *gin.Context) {
 		return
 	}
 
+	ctx.Header("Location", "/reservation/"+res.ID)
 	ctx.JSON(http.StatusOK, reservationModelToResponse(res))
 }
 
@@ -70,6 +83,8 @@ type createReservationRequest struct {
 	PaymentDetails json.RawMessage `json:"payment_details"`
 	StartDate      *TimeJSON       `json:"start_date"`
 	EndDate        *TimeJSON       `json:"end_date"`
+	// DisplayCurrency is currency to show cost in, cost is paid in base currency of hotel
+	DisplayCurrency string `json:"display_currency"`
 }
 
 type roomsRequest struct {
@@ -84,9 +99,10 @@ func (h *reservationHandler) requestToModel(r createReservationRequest, userID s
 	}
 
 	res := booking.ReservationRequest{
-		ID:      r.ID,
-		HotelID: r.HotelID,
-		UserID:  userID,
+		ID:              r.ID,
+		HotelID:         r.HotelID,
+		UserID:          userID,
+		DisplayCurrency: money.Currency(r.DisplayCurrency),
 	}
 
 	var err error
@@ -129,14 +145,28 @@ var (
 	roomsCountError    = httpError{code: http.StatusBadRequest, text: "rooms count cant be less than 1"}
 	datesOrderError    = httpError{code: http.StatusBadRequest, text: "start_date is after end_date"}
 	wrongDatesError    = httpError{code: http.StatusBadRequest, text: "dates must be not before today"}
+	idFormatError      = httpError{code: http.StatusBadRequest, text: "id must be up to 64 latin letters, digits, '-' or '_'"}
+	currencyError      = httpError{code: http.StatusBadRequest, text: "display_currency must be ISO 4217 code, e.g. EUR"}
+	unknownRateError   = httpError{code: http.StatusBadRequest, text: "cost can not be shown in display_currency"}
+	ratesError         = httpError{code: http.StatusServiceUnavailable, text: "exchange rates are unavailable"}
 )
 
 // validate checks
 func (h *reservationHandler) validate(req *booking.ReservationRequest) error {
+	// empty id is generated by service
+	if req.ID != "" && !booking.IsValidReservationID(req.ID) {
+		return idFormatError
+	}
+
 	if _, ok := h.p.GetSources()[req.PaymentType]; !ok {
 		return paymentTypeError
 	}
 
+	// empty currency is base currency of hotel
+	if req.DisplayCurrency != "" && !money.IsValidCurrency(req.DisplayCurrency) {
+		return currencyError
+	}
+
 	if len(req.RoomsRequest) == 0 {
 		return roomsCountError
 	}
@@ -161,3 +191,19 @@ func (h *reservationHandler) validate(req *booking.ReservationRequest) error {
 
 	return nil
 }
+
+// validateDisplayCurrency checks that cost in base currency of hotel can be converted to display currency,
+// otherwise price calculation fails after rooms are booked
+func (h *reservationHandler) validateDisplayCurrency(ctx context.Context, req *booking.ReservationRequest) error {
+	base := money.Currency(h.cnf.Currency.HotelCurrency(req.HotelID))
+	if req.DisplayCurrency == "" || req.DisplayCurrency == base {
+		return nil
+	}
+
+	if _, err := h.rates.Rate(ctx, base, req.DisplayCurrency); errors.Is(err, money.ErrUnknownRate) {
+		return unknownRateError
+	} else if err != nil {
+		return ratesError
+	}
+	return nil
+}
@@ -1,13 +1,23 @@
 package handlers
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/antnmxmv/booking-service/internal/booking"
 	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
 	"github.com/antnmxmv/booking-service/internal/payment"
+	"github.com/antnmxmv/booking-service/internal/storage/inmemory"
+	"github.com/antnmxmv/booking-service/pkg/queue"
+	"github.com/gin-gonic/gin"
 )
 
 const (
@@ -204,6 +214,32 @@ func Test_reservationHandler_validate(t *testing.T) {
 			},
 			out: wrongDatesError,
 		},
+		{
+			name: "id format error",
+			in: &booking.ReservationRequest{
+				ID: "../1",
+				RoomsRequest: []booking.RoomRequest{
+					{RoomType: "lux", Count: 1},
+				},
+				PaymentType: "cash",
+				StartDate:   toDay(time.Now()),
+				EndDate:     toDay(time.Now()),
+			},
+			out: idFormatError,
+		},
+		{
+			name: "display currency format error",
+			in: &booking.ReservationRequest{
+				RoomsRequest: []booking.RoomRequest{
+					{RoomType: "lux", Count: 1},
+				},
+				PaymentType:     "cash",
+				StartDate:       toDay(time.Now()),
+				EndDate:         toDay(time.Now()),
+				DisplayCurrency: "euro",
+			},
+			out: currencyError,
+		},
 		{
 			name: "payment details parsing",
 			in: &booking.ReservationRequest{
@@ -227,3 +263,57 @@ func Test_reservationHandler_validate(t *testing.T) {
 		})
 	}
 }
+
+func Test_reservationHandler_displayCurrency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ratesPath := filepath.Join(t.TempDir(), "rates.yml")
+	if err := os.WriteFile(ratesPath, []byte("base: USD\nrates:\n  EUR: 0.9\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cnf := &config.Config{Currency: config.Currency{Default: "USD", RatesPath: ratesPath}}
+	rates := money.NewStaticRateProvider(cnf)
+	if err := rates.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo := inmemory.NewStorage().WithRoomAvailability([]*inmemory.RoomAvailability{
+		{HotelID: validHotelID, RoomType: "eco", Date: date, Quota: 10},
+	})
+	s := booking.NewBookingService(cnf, repo, booking.NewReservationOrchestrator(repo, queue.NewDelayedQueue[booking.StepDeadline](), nil), queue.NewDelayedQueue[string]())
+
+	r := gin.New()
+	r.POST("/reservation/", NewCreateReservationHandler(cnf, s, payment.NewPaymentProvider(paymentProviders...), rates))
+
+	tests := []struct {
+		name     string
+		currency string
+		wantCode int
+	}{
+		{name: "base currency of hotel", currency: "", wantCode: http.StatusOK},
+		{name: "known rate", currency: "EUR", wantCode: http.StatusOK},
+		{name: "unknown rate", currency: "XYZ", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"hotel_id":"` + validHotelID + `","rooms":[{"type":"eco","count":1}],"payment_type":"cash",` +
+				`"start_date":"2030-01-01T00:00:00Z","end_date":"2030-01-01T00:00:00Z","display_currency":"` + tt.currency + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/reservation/", strings.NewReader(body))
+			req.Header.Set("user_id", tt.name)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d %s, want %d", w.Code, w.Body.String(), tt.wantCode)
+			}
+			stored, err := repo.GetReservationsByUserID(context.Background(), tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantStored := tt.wantCode == http.StatusOK; (len(stored) == 1) != wantStored {
+				t.Errorf("%d reservations are stored, want stored %v", len(stored), wantStored)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/gin-gonic/gin"
+)
+
+type getReservationHistoryHandler struct {
+	s *booking.BookingService
+}
+
+func NewGetReservationHistoryHandler(bookingService *booking.BookingService) gin.HandlerFunc {
+	return (&getReservationHistoryHandler{s: bookingService}).handlerFn
+}
+
+func (h *getReservationHistoryHandler) handlerFn(ctx *gin.Context) {
+	setHeaders(ctx)
+
+	entries, err := h.s.GetReservationHistory(ctx.Request.Context(), ctx.GetHeader("user_id"), ctx.Param("id"))
+
+	if err != nil {
+		if errors.Is(err, booking.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, errorJSON(err.Error()))
+		} else if errors.Is(err, booking.ErrNotOwner) {
+			ctx.JSON(http.StatusForbidden, errorJSON(err.Error()))
+		} else {
+			ctx.JSON(http.StatusInternalServerError, errorJSON(http.StatusText(http.StatusInternalServerError)))
+		}
+
+		return
+	}
+
+	res := make([]sagaLogEntryResponse, len(entries))
+
+	for i := range entries {
+		res[i] = sagaLogEntryModelToResponse(entries[i])
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
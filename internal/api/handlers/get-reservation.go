@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/gin-gonic/gin"
+)
+
+type getReservationHandler struct {
+	s *booking.BookingService
+}
+
+func NewGetReservationHandler(bookingService *booking.BookingService) gin.HandlerFunc {
+	return (&getReservationHandler{s: bookingService}).handlerFn
+}
+
+func (h *getReservationHandler) handlerFn(ctx *gin.Context) {
+	setHeaders(ctx)
+
+	res, err := h.s.GetUserReservation(ctx.Request.Context(), ctx.GetHeader("user_id"), ctx.Param("id"))
+
+	if err != nil {
+		if errors.Is(err, booking.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, errorJSON(err.Error()))
+		} else if errors.Is(err, booking.ErrNotOwner) {
+			ctx.JSON(http.StatusForbidden, errorJSON(err.Error()))
+		} else {
+			ctx.JSON(http.StatusInternalServerError, errorJSON(http.StatusText(http.StatusInternalServerError)))
+		}
+
+		return
+	}
+
+	ctx.JSON(http.StatusOK, reservationModelToResponse(res))
+}
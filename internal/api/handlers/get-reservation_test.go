@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/storage/inmemory"
+	"github.com/antnmxmv/booking-service/pkg/queue"
+	"github.com/gin-gonic/gin"
+)
+
+func Test_getReservationHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	date := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo := inmemory.NewStorage().WithRoomAvailability([]*inmemory.RoomAvailability{
+		{HotelID: validHotelID, RoomType: "eco", Date: date, Quota: 1},
+	})
+	_, err := repo.CreateReservation(context.Background(), &booking.ReservationRequest{
+		ID:           "1",
+		UserID:       "owner",
+		HotelID:      validHotelID,
+		RoomsRequest: booking.RoomsRequest{{RoomType: "eco", Count: 1}},
+		PaymentType:  "cash",
+		StartDate:    date,
+		EndDate:      date,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cnf := &config.Config{}
+	s := booking.NewBookingService(cnf, repo, booking.NewReservationOrchestrator(repo, queue.NewDelayedQueue[booking.StepDeadline](), nil), queue.NewDelayedQueue[string]())
+
+	r := gin.New()
+	r.GET("/reservation/:id", NewGetReservationHandler(s))
+
+	tests := []struct {
+		name     string
+		id       string
+		userID   string
+		wantCode int
+	}{
+		{name: "own reservation", id: "1", userID: "owner", wantCode: http.StatusOK},
+		{name: "not found", id: "2", userID: "owner", wantCode: http.StatusNotFound},
+		{name: "other user's reservation", id: "1", userID: "other", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/reservation/"+tt.id, nil)
+			req.Header.Set("user_id", tt.userID)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d, body: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			res := reservationResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.ID != tt.id || res.HotelID != validHotelID {
+				t.Errorf("reservation = %s of hotel %s, want %s of hotel %s", res.ID, res.HotelID, tt.id, validHotelID)
+			}
+		})
+	}
+}
@@ -18,7 +18,7 @@ func NewGetReservationsHandler(bookingService *booking.BookingService) gin.Handl
 func (h *getReservationsHandler) handlerFn(ctx *gin.Context) {
 	setHeaders(ctx)
 
-	reservations, err := h.s.GetUserReservations(ctx.GetHeader("user_id"))
+	reservations, err := h.s.GetUserReservations(ctx.Request.Context(), ctx.GetHeader("user_id"))
 
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, errorJSON(http.StatusText(http.StatusInternalServerError)))
@@ -25,7 +25,7 @@ func (h *getRoomsHandler) handlerFn(ctx *gin.Context) {
 		return
 	}
 
-	res, err := h.s.GetAvailableRoomTypes(hotelID)
+	res, err := h.s.GetAvailableRoomTypes(ctx.Request.Context(), hotelID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.Error{Err: errors.New(http.StatusText(http.StatusInternalServerError))})
 		return
@@ -50,11 +50,13 @@ func (t *TimeJSON) UnmarshalJSON(bts []byte) error {
 	return err
 }
 
-func reservationStatusToResponse(status booking.ReservationStatus) string {
-	if status == booking.CanceledReservationStatus {
+func reservationStatusToResponse(r *booking.Reservation) string {
+	if r.Status == booking.CanceledReservationStatus {
 		return "canceled"
-	} else if status == booking.FinishedReservationStatus {
+	} else if r.Status == booking.FinishedReservationStatus {
 		return "finished"
+	} else if r.Failure != nil && r.Failure.Action == booking.AwaitUserFailureAction {
+		return "action_required"
 	}
 	return "in_progress"
 }
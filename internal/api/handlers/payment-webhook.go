@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/antnmxmv/booking-service/internal/payment"
+	"github.com/gin-gonic/gin"
+)
+
+type paymentWebhookHandler struct {
+	p *payment.Provider
+}
+
+// NewPaymentWebhookHandler accepts order status callbacks of acquirers
+func NewPaymentWebhookHandler(paymentProvider *payment.Provider) gin.HandlerFunc {
+	return (&paymentWebhookHandler{p: paymentProvider}).handlerFn
+}
+
+func (h *paymentWebhookHandler) handlerFn(ctx *gin.Context) {
+	setHeaders(ctx)
+
+	// signature is made of raw body, so it is not decoded here
+	payload, err := ctx.GetRawData()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorJSON("request body can not be read"))
+		return
+	}
+
+	order, err := h.p.HandleWebhook(ctx.Request.Context(), payment.SourceType(ctx.Param("source")), payload,
+		ctx.GetHeader(payment.TimestampHeader), ctx.GetHeader(payment.SignatureHeader))
+
+	if err != nil {
+		if errors.Is(err, payment.ErrWebhookNotSupported) {
+			ctx.JSON(http.StatusNotFound, errorJSON(err.Error()))
+		} else if errors.Is(err, payment.ErrInvalidSignature) {
+			ctx.JSON(http.StatusUnauthorized, errorJSON(err.Error()))
+		} else if errors.Is(err, payment.ErrInvalidWebhook) {
+			ctx.JSON(http.StatusBadRequest, errorJSON(err.Error()))
+		} else {
+			ctx.JSON(http.StatusInternalServerError, errorJSON(http.StatusText(http.StatusInternalServerError)))
+		}
+
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"reservation_id": order.ReservationID(), "status": order.Status()})
+}
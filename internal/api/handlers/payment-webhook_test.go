@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
+	"github.com/antnmxmv/booking-service/internal/payment"
+	"github.com/gin-gonic/gin"
+)
+
+func TestPaymentWebhook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const secret = "secret"
+	cnf := &config.Config{Payment: config.Payment{Card: config.Card{WebhookSecret: secret}}}
+	p := payment.NewPaymentProvider(payment.NewCashSource(), payment.NewCardSource(cnf))
+
+	r := gin.New()
+	r.POST("/payment/webhook/:source", NewPaymentWebhookHandler(p))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	updates := make(chan payment.Order, 1)
+	go func() {
+		for order := range p.SubscribeOnStatusUpdates() {
+			updates <- order
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	details, err := p.UnmarshalDetailsJSON("card", []byte(`{"card_id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.CreateOrder(ctx, "1", money.New(100, "EUR"), "card", details, "command"); err != nil {
+		t.Fatal(err)
+	}
+
+	acquirer := payment.NewFakeAcquirer(server.URL+"/payment/webhook/card", secret)
+	if err := acquirer.Notify(ctx, payment.CardWebhookPayload{ReservationID: "1", Status: payment.PaymentStatusSuccess, Amount: 100, Currency: "EUR"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case order := <-updates:
+		if order.ReservationID() != "1" || order.Status() != payment.PaymentStatusSuccess {
+			t.Errorf("order update = %s %s, want 1 %s", order.ReservationID(), order.Status(), payment.PaymentStatusSuccess)
+		}
+	case <-ctx.Done():
+		t.Fatal("order update is not received")
+	}
+
+	// relayed order creation returns state of the last webhook
+	if order, err := p.CreateOrder(ctx, "1", money.New(100, "EUR"), "card", details, "command"); err != nil || order.Status() != payment.PaymentStatusSuccess {
+		t.Errorf("relayed order = %v, %v, want order with status %s", order, err, payment.PaymentStatusSuccess)
+	}
+
+	for name, msg := range map[string]payment.CardWebhookPayload{
+		"amount mismatch":   {ReservationID: "1", Status: payment.PaymentStatusRefunded, Amount: 1, Refunded: 1, Currency: "EUR"},
+		"currency mismatch": {ReservationID: "1", Status: payment.PaymentStatusRefunded, Amount: 100, Refunded: 100, Currency: "USD"},
+		"unknown order":     {ReservationID: "2", Status: payment.PaymentStatusSuccess, Amount: 100, Currency: "EUR"},
+	} {
+		if err := acquirer.Notify(ctx, msg); err == nil {
+			t.Errorf("callback with %s is accepted", name)
+		}
+	}
+
+	if err := payment.NewFakeAcquirer(server.URL+"/payment/webhook/card", "wrong secret").Notify(ctx, payment.CardWebhookPayload{ReservationID: "1", Status: payment.PaymentStatusFailed, Amount: 100, Currency: "EUR"}); err == nil {
+		t.Error("callback with wrong signature is accepted")
+	}
+
+	if err := payment.NewFakeAcquirer(server.URL+"/payment/webhook/cash", secret).Notify(ctx, payment.CardWebhookPayload{ReservationID: "1", Status: payment.PaymentStatusFailed, Amount: 100, Currency: "EUR"}); err == nil {
+		t.Error("callback of source without webhooks is accepted")
+	}
+
+	// captured callback can not be replayed after tolerance
+	payload := []byte(`{"reservation_id":"1","status":"failed","amount":100,"currency":"EUR"}`)
+	timestamp := strconv.FormatInt(time.Now().Add(-payment.WebhookTolerance*2).Unix(), 10)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/payment/webhook/card", bytes.NewReader(payload))
+	req.Header.Set(payment.TimestampHeader, timestamp)
+	req.Header.Set(payment.SignatureHeader, payment.Sign(secret, timestamp, payload))
+	if resp, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	} else if resp.Body.Close(); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("replayed callback status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	select {
+	case order := <-updates:
+		t.Errorf("rejected callback produced order update %s %s", order.ReservationID(), order.Status())
+	default:
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	statusEventName = "status"
+	// heartbeatInterval keeps idle connection open through proxies
+	heartbeatInterval = 15 * time.Second
+)
+
+type reservationEventsHandler struct {
+	s *booking.BookingService
+}
+
+func NewReservationEventsHandler(bookingService *booking.BookingService) gin.HandlerFunc {
+	return (&reservationEventsHandler{s: bookingService}).handlerFn
+}
+
+// handlerFn streams reservation as server-sent event on every status change.
+// current reservation is sent first, stream ends when reservation is finished or canceled
+func (h *reservationEventsHandler) handlerFn(ctx *gin.Context) {
+	res, changes, unsubscribe, err := h.s.SubscribeOnStatusChanges(ctx.Request.Context(), ctx.GetHeader("user_id"), ctx.Param("id"))
+
+	if err != nil {
+		setHeaders(ctx)
+		if errors.Is(err, booking.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, errorJSON(err.Error()))
+		} else if errors.Is(err, booking.ErrNotOwner) {
+			ctx.JSON(http.StatusForbidden, errorJSON(err.Error()))
+		} else {
+			ctx.JSON(http.StatusInternalServerError, errorJSON(http.StatusText(http.StatusInternalServerError)))
+		}
+
+		return
+	}
+	defer unsubscribe()
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+
+	ctx.SSEvent(statusEventName, reservationModelToResponse(res))
+	if isFinalStatus(res.Status) {
+		ctx.Writer.Flush()
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case r, ok := <-changes:
+			if !ok {
+				return false
+			}
+			ctx.SSEvent(statusEventName, reservationModelToResponse(&r))
+			return !isFinalStatus(r.Status)
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			return err == nil
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func isFinalStatus(status booking.ReservationStatus) bool {
+	return status == booking.FinishedReservationStatus || status == booking.CanceledReservationStatus
+}
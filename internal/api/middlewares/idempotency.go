@@ -0,0 +1,112 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/idempotency"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks response which is saved response of the first request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// responseRecorder keeps copy of response body
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency saves the first response of request with Idempotency-Key header and returns it
+// on retries with the same key. reused key with different request is rejected.
+// key is released if request fails with server error and nothing is stored, so it can be retried.
+// server error with Location header is saved like success, created resource must not be created again.
+// keys expire by server config, key of lost request in progress is released after lease timeout
+func Idempotency(cnf *config.Config, store idempotency.Store) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "request body can not be read"})
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		record := &idempotency.Record{
+			UserID:      ctx.GetHeader("user_id"),
+			Key:         key,
+			Fingerprint: fingerprint(ctx.Request, body),
+			CreatedAt:   time.Now(),
+		}
+
+		stored, err := store.CreateIdempotencyKey(ctx.Request.Context(), record, idempotency.ExpirationFromConfig(cnf))
+		if errors.Is(err, idempotency.ErrKeyExists) {
+			if stored.Fingerprint != record.Fingerprint {
+				ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "idempotency key is reused with different request"})
+			} else if !stored.IsCompleted() {
+				ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "request with this idempotency key is in progress"})
+			} else {
+				ctx.Header(IdempotentReplayedHeader, "true")
+				ctx.Data(stored.StatusCode, "application/json; charset=utf-8", stored.Body)
+				ctx.Abort()
+			}
+			return
+		} else if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": http.StatusText(http.StatusInternalServerError)})
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: ctx.Writer}
+		ctx.Writer = recorder
+
+		ctx.Next()
+
+		// response must be saved even if client is already gone
+		saveCtx := context.Background()
+
+		if ctx.Writer.Status() >= http.StatusInternalServerError && ctx.Writer.Header().Get("Location") == "" {
+			_ = store.DeleteIdempotencyKey(saveCtx, record.UserID, record.Key)
+			return
+		}
+
+		record.StatusCode = ctx.Writer.Status()
+		record.Body = recorder.body.Bytes()
+		_ = store.CompleteIdempotencyKey(saveCtx, record)
+	}
+}
+
+// fingerprint is hash of request method, path and body
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,107 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/idempotency"
+	"github.com/antnmxmv/booking-service/internal/storage/inmemory"
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	calls := 0
+	status := http.StatusOK
+	// stored makes handler fail after resource is created
+	stored := false
+
+	cnf := &config.Config{Server: config.Server{Idempotency: config.Idempotency{KeyTTL: time.Hour, LeaseTimeout: time.Minute}}}
+	store := inmemory.NewStorage()
+
+	r := gin.New()
+	r.POST("/reservation/", Idempotency(cnf, store), func(ctx *gin.Context) {
+		calls++
+		if stored {
+			ctx.Header("Location", "/reservation/"+strconv.Itoa(calls))
+		}
+		ctx.JSON(status, gin.H{"call": calls})
+	})
+
+	do := func(key, userID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/reservation/", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		req.Header.Set("user_id", userID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := do("key", "user", `{"id":"1"}`)
+
+	retry := do("key", "user", `{"id":"1"}`)
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || calls != 1 {
+		t.Errorf("retry response = %d %s, want %d %s without new call", retry.Code, retry.Body.String(), first.Code, first.Body.String())
+	}
+	if retry.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Error("retry response is not marked as replayed")
+	}
+
+	if w := do("key", "user", `{"id":"2"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with different request status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	// keys of different users don't intersect
+	if do("key", "another user", `{"id":"1"}`); calls != 2 {
+		t.Errorf("handler is called %d times, want 2", calls)
+	}
+
+	// server error releases key
+	status = http.StatusInternalServerError
+	do("failed", "user", `{"id":"3"}`)
+	status = http.StatusOK
+	if w := do("failed", "user", `{"id":"3"}`); w.Code != http.StatusOK || calls != 4 {
+		t.Errorf("retry of failed request status = %d and handler is called %d times, want %d and 4", w.Code, calls, http.StatusOK)
+	}
+
+	// server error after resource is stored keeps key, retry gets the stored resource
+	status, stored = http.StatusInternalServerError, true
+	failed := do("stored", "user", `{"id":"4"}`)
+	status, stored = http.StatusOK, false
+	retry = do("stored", "user", `{"id":"4"}`)
+	if calls != 5 || retry.Code != failed.Code || retry.Body.String() != failed.Body.String() {
+		t.Errorf("retry of stored request = %d %s and handler is called %d times, want saved response of the first call",
+			retry.Code, retry.Body.String(), calls)
+	}
+
+	// key of request in progress is released after lease timeout, e.g. if server was restarted
+	for _, tt := range []struct {
+		key       string
+		createdAt time.Time
+		wantCode  int
+	}{
+		{key: "in progress", createdAt: time.Now(), wantCode: http.StatusConflict},
+		{key: "lost", createdAt: time.Now().Add(-time.Hour), wantCode: http.StatusOK},
+	} {
+		body := `{"id":"4"}`
+		record := &idempotency.Record{
+			UserID:      "user",
+			Key:         tt.key,
+			Fingerprint: fingerprint(httptest.NewRequest(http.MethodPost, "/reservation/", nil), []byte(body)),
+			CreatedAt:   tt.createdAt,
+		}
+		if _, err := store.CreateIdempotencyKey(context.Background(), record, idempotency.Expiration{}); err != nil {
+			t.Fatal(err)
+		}
+		if w := do(tt.key, "user", body); w.Code != tt.wantCode {
+			t.Errorf("request with key %q status = %d, want %d", tt.key, w.Code, tt.wantCode)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"github.com/antnmxmv/booking-service/internal/api/middlewares"
 	"github.com/antnmxmv/booking-service/internal/booking"
 	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/idempotency"
+	"github.com/antnmxmv/booking-service/internal/money"
 	"github.com/antnmxmv/booking-service/internal/payment"
 	"github.com/gin-gonic/gin"
 )
@@ -17,18 +19,23 @@ type Controller struct {
 	cfg              *config.Config
 	s                *booking.BookingService
 	p                *payment.Provider
+	idempotencyStore idempotency.Store
 	isReady          handlers.ReadinessMonitor
 	server           *http.Server
 	prometheusServer *middlewares.Prometheus
+	// rates check that cost can be shown in requested currency
+	rates money.RateProvider
 }
 
-func NewController(conf *config.Config, s *booking.BookingService, p *payment.Provider, readinessMonitor handlers.ReadinessMonitor, prometheus *middlewares.Prometheus) *Controller {
+func NewController(conf *config.Config, s *booking.BookingService, p *payment.Provider, idempotencyStore idempotency.Store, readinessMonitor handlers.ReadinessMonitor, prometheus *middlewares.Prometheus, rates money.RateProvider) *Controller {
 	return &Controller{
 		s:                s,
 		cfg:              conf,
 		p:                p,
+		idempotencyStore: idempotencyStore,
 		isReady:          readinessMonitor,
 		prometheusServer: prometheus,
+		rates:            rates,
 	}
 }
 
@@ -48,8 +55,14 @@ func (c *Controller) buildServer() {
 	r.Use(interceptors...)
 
 	r.GET("/reservation/", handlers.NewGetReservationsHandler(c.s))
-	r.POST("/reservation/", c.prometheusServer.Middleware("create_reservation"), handlers.NewCreateReservationHandler(c.s, c.p))
+	r.GET("/reservation/:id", handlers.NewGetReservationHandler(c.s))
+	r.GET("/reservation/:id/events", handlers.NewReservationEventsHandler(c.s))
+	r.GET("/reservation/:id/history", handlers.NewGetReservationHistoryHandler(c.s))
+	r.POST("/reservation/", c.prometheusServer.Middleware("create_reservation"), middlewares.Idempotency(c.cfg, c.idempotencyStore), handlers.NewCreateReservationHandler(c.cfg, c.s, c.p, c.rates))
+	r.DELETE("/reservation/:id", c.prometheusServer.Middleware("cancel_reservation"), handlers.NewCancelReservationHandler(c.s))
+	r.PUT("/reservation/:id/payment", c.prometheusServer.Middleware("change_payment_method"), handlers.NewChangePaymentMethodHandler(c.s, c.p))
 	r.GET("/hotel/:hotelID/", handlers.NewGetRoomsHandler(c.s))
+	r.POST("/payment/webhook/:source", c.prometheusServer.Middleware("payment_webhook"), handlers.NewPaymentWebhookHandler(c.p))
 
 	r.Handle(http.MethodGet, "/readyz", handlers.NewReadyzHandler(c.isReady))
 
@@ -2,6 +2,7 @@ package booking
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/antnmxmv/booking-service/internal/config"
@@ -18,7 +19,11 @@ type BookingService struct {
 	cancelationQueue DelayedQueue
 	// reservationOrchestrator handles reservations lifecycle
 	reservationOrchestrator *ReservationOrchestrator
-	doneCh                  chan struct{}
+	// statuses delivers reservation status changes to clients
+	statuses *statusBroker
+	// ctx is context of background work, it is canceled on stop
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewBookingService(
@@ -27,16 +32,33 @@ func NewBookingService(
 	reservationOrchestrator *ReservationOrchestrator,
 	queue DelayedQueue,
 ) *BookingService {
-	return &BookingService{
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &BookingService{
 		config:                  cnf,
 		repo:                    repo,
 		cancelationQueue:        queue,
 		reservationOrchestrator: reservationOrchestrator,
-		doneCh:                  make(chan struct{}),
+		statuses:                newStatusBroker(),
+		ctx:                     ctx,
+		cancel:                  cancel,
 	}
+
+	reservationOrchestrator.onStatusChanged = s.statuses.publish
+
+	// completed reservation can not become idle, so there is nothing to check
+	reservationOrchestrator.onCompleted = func(reservationID string) {
+		_ = s.cancelationQueue.Cancel(reservationID)
+	}
+
+	return s
 }
 
-func (s *BookingService) CreateReservation(userID string, request *ReservationRequest) (*Reservation, error) {
+// CreateReservation books rooms and runs jobs over reservation. id is generated if request has no id.
+// stored reservation is returned with error if jobs failed after it was booked
+func (s *BookingService) CreateReservation(ctx context.Context, userID string, request *ReservationRequest) (*Reservation, error) {
+	if request.ID == "" {
+		request.ID = NewReservationID()
+	}
 
 	// send to cancelation queue
 	if err := s.cancelationQueue.SendMessage(request.ID, s.config.Booking.IdleReservationTimeout); err != nil {
@@ -44,37 +66,61 @@ func (s *BookingService) CreateReservation(userID string, request *ReservationRe
 	}
 
 	// make transaction in db, check if we have available dates
-	reservation, err := s.repo.CreateReservation(request)
+	reservation, err := s.repo.CreateReservation(ctx, request)
 	if err != nil {
 		return nil, err
 	}
 
 	// execute all jobs over this reservation very consistently
-	if err := s.reservationOrchestrator.execute(reservation, false); err != nil {
-		return nil, err
+	if err := s.reservationOrchestrator.execute(ctx, reservation, false); err != nil {
+		return reservation, err
 	}
 
 	return reservation, nil
 }
 
-func (s *BookingService) ChangePaymentMethod(reservationID string, method payment.SourceType, details payment.OrderDetails) (*Reservation, error) {
+// ChangePaymentMethod compensates done jobs of user's reservation and runs them again with new payment method
+func (s *BookingService) ChangePaymentMethod(ctx context.Context, userID, reservationID string, method payment.SourceType, details payment.OrderDetails) (*Reservation, error) {
 
-	r, err := s.repo.GetReservationByID(reservationID)
+	r, err := s.repo.GetReservationByID(ctx, reservationID)
 	if err != nil {
 		return r, err
 	}
 
-	err = s.reservationOrchestrator.rollback(r, false)
+	if r.UserID != userID {
+		return nil, ErrNotOwner
+	}
+
+	if r.Status == CanceledReservationStatus {
+		return r, ErrAlreadyCanceled
+	} else if r.Status == FinishedReservationStatus {
+		return r, ErrAlreadyFinished
+	}
+
+	err = s.reservationOrchestrator.rollback(ctx, r, false)
 
-	if err := s.repo.UpdateReservation(r); err != nil {
+	if err == nil {
+		r.PaymentType = method
+		r.PaymentRequestDetails = details
+		r.PaymentOrder = nil
+		// jobs are run again from the beginning
+		r.StepAttempts = nil
+		r.Steps = nil
+		r.Failure = nil
+		// user is active, so reservation is not idle anymore
+		r.LastUpdateTime = time.Now()
+	}
+
+	if err := s.repo.UpdateReservation(ctx, r); err != nil {
 		return r, err
 	}
+	s.statuses.publish(r)
 
 	if err != nil {
 		return r, err
 	}
 
-	err = s.reservationOrchestrator.execute(r, true)
+	err = s.reservationOrchestrator.execute(ctx, r, true)
 
 	if err != nil {
 		return r, err
@@ -83,48 +129,129 @@ func (s *BookingService) ChangePaymentMethod(reservationID string, method paymen
 	return r, nil
 }
 
-func (s *BookingService) GetUserReservations(userID string) ([]*Reservation, error) {
-	return s.repo.GetReservationsByUserID(userID)
+// CancelReservation compensates all done jobs of user's reservation
+// (e.g. cancels payment order) and releases rooms quota
+func (s *BookingService) CancelReservation(ctx context.Context, userID, reservationID string) (*Reservation, error) {
+	var res *Reservation
+
+	// reservation could be changed by orchestrator meanwhile, then it is checked and compensated again
+	err := s.reservationOrchestrator.retryOnConflict(ctx, reservationID, func(r *Reservation) error {
+		res = nil
+		if r.UserID != userID {
+			return ErrNotOwner
+		}
+
+		res = r
+		if r.Status == CanceledReservationStatus {
+			return ErrAlreadyCanceled
+		}
+
+		return s.reservationOrchestrator.compensate(ctx, r)
+	})
+	if err != nil {
+		return res, err
+	}
+
+	return s.repo.GetReservationByID(ctx, reservationID)
 }
 
-func (s *BookingService) GetAvailableRoomTypes(hotelID string) ([]*RoomAvailability, error) {
-	return s.repo.GetRoomsByDates(hotelID, time.Now(), time.Now().Add(roomsAvailabilityRequestWindow))
+// SubscribeOnStatusChanges returns user's reservation and channel of its further changes.
+// unsubscribe must be called when changes are not needed anymore
+func (s *BookingService) SubscribeOnStatusChanges(ctx context.Context, userID, reservationID string) (r *Reservation, changes <-chan Reservation, unsubscribe func(), err error) {
+	// subscription goes first, so change made between reading and subscribing is not lost
+	changes, unsubscribe = s.statuses.subscribe(reservationID)
+
+	r, err = s.GetUserReservation(ctx, userID, reservationID)
+	if err != nil {
+		unsubscribe()
+		return nil, nil, nil, err
+	}
+
+	return r, changes, unsubscribe, nil
 }
 
-func (s *BookingService) Start(ctx context.Context) error {
+// GetUserReservation returns reservation if it belongs to user
+func (s *BookingService) GetUserReservation(ctx context.Context, userID, reservationID string) (*Reservation, error) {
+	r, err := s.repo.GetReservationByID(ctx, reservationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.UserID != userID {
+		return nil, ErrNotOwner
+	}
+
+	return r, nil
+}
+
+// GetReservationHistory returns saga log of reservation to its owner or support staff
+func (s *BookingService) GetReservationHistory(ctx context.Context, userID, reservationID string) ([]*SagaLogEntry, error) {
+	r, err := s.repo.GetReservationByID(ctx, reservationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.UserID != userID && !s.isSupportUser(userID) {
+		return nil, ErrNotOwner
+	}
+
+	return s.repo.GetSagaLog(ctx, reservationID)
+}
+
+func (s *BookingService) isSupportUser(userID string) bool {
+	for _, id := range s.config.Booking.SupportUserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *BookingService) GetUserReservations(ctx context.Context, userID string) ([]*Reservation, error) {
+	return s.repo.GetReservationsByUserID(ctx, userID)
+}
+
+func (s *BookingService) GetAvailableRoomTypes(ctx context.Context, hotelID string) ([]*RoomAvailability, error) {
+	return s.repo.GetRoomsByDates(ctx, hotelID, time.Now(), time.Now().Add(roomsAvailabilityRequestWindow))
+}
+
+func (s *BookingService) Start(_ context.Context) error {
 	go func() {
+		ctx := s.ctx
 		queue := s.cancelationQueue.Subscribe()
 		for {
 			select {
 			case reservationID := <-queue:
-				reservation, err := s.repo.GetReservationByID(reservationID)
-				if err != nil {
-					break
-				}
-				if reservation.Status == FinishedReservationStatus ||
-					reservation.Status == CanceledReservationStatus {
-					break
-				}
-				// update status or requeue
-				if time.Since(reservation.LastUpdateTime) > s.config.Booking.IdleReservationTimeout {
-					if err := s.repo.CancelReservation(reservationID); err != nil {
-						_ = s.cancelationQueue.SendMessage(reservationID, time.Minute)
+				// message is acknowledged only when it is processed,
+				// otherwise queue will deliver it again
+				err := s.reservationOrchestrator.retryOnConflict(ctx, reservationID, func(reservation *Reservation) error {
+					if reservation.Status == FinishedReservationStatus ||
+						reservation.Status == CanceledReservationStatus {
+						return nil
+					}
+					// update status or requeue
+					if time.Since(reservation.LastUpdateTime) > s.config.Booking.IdleReservationTimeout {
+						return s.reservationOrchestrator.compensate(ctx, reservation)
 					}
-				} else {
-					_ = s.cancelationQueue.SendMessage(reservationID, s.config.Booking.IdleReservationTimeout-time.Since(reservation.LastUpdateTime))
+					return s.cancelationQueue.SendMessage(reservationID, s.config.Booking.IdleReservationTimeout-time.Since(reservation.LastUpdateTime))
+				})
+				// reservation is not found if its creation failed after message was sent
+				if err != nil && !errors.Is(err, ErrNotFound) {
+					break
 				}
+				_ = s.cancelationQueue.Ack(reservationID)
 
-			case <-s.doneCh:
+			case <-ctx.Done():
 				return
 			}
 		}
 	}()
 
-	return s.reservationOrchestrator.run(s.config.Booking.IdleReservationTimeout)
+	return s.reservationOrchestrator.run(s.config.Booking)
 }
 
-func (s *BookingService) Stop(ctx context.Context) error {
-	close(s.doneCh)
+func (s *BookingService) Stop(_ context.Context) error {
+	s.cancel()
 	s.reservationOrchestrator.stop()
 	return nil
 }
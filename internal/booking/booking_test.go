@@ -0,0 +1,797 @@
+package booking_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/storage/inmemory"
+	"github.com/antnmxmv/booking-service/pkg/queue"
+)
+
+const testHotelID = "hotel"
+
+var testDate = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeJob records calls and answers with configured results
+type fakeJob struct {
+	name      booking.ReservationStatus
+	runResult *bool
+	runErr    error
+	// failRuns is count of first runs which return runErr, all runs return it when zero
+	failRuns int
+	ch       chan booking.JobResponse
+
+	mux     sync.Mutex
+	runs    int
+	cancels int
+	// lastRun is copy of reservation from the last run
+	lastRun booking.Reservation
+	// lastCtx is context of the last run
+	lastCtx context.Context
+}
+
+func newFakeJob(name booking.ReservationStatus, runResult *bool) *fakeJob {
+	return &fakeJob{name: name, runResult: runResult, ch: make(chan booking.JobResponse)}
+}
+
+func (j *fakeJob) Name() booking.ReservationStatus {
+	return j.name
+}
+
+func (j *fakeJob) Run(ctx context.Context, r *booking.Reservation) (*bool, error) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	j.runs++
+	j.lastRun = *r
+	j.lastCtx = ctx
+	if j.failRuns > 0 && j.runs > j.failRuns {
+		return j.runResult, nil
+	}
+	return j.runResult, j.runErr
+}
+
+func (j *fakeJob) Cancel(_ context.Context, _ *booking.Reservation) (*bool, error) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	j.cancels++
+	res := true
+	return &res, nil
+}
+
+func (j *fakeJob) Subscribe() (<-chan booking.JobResponse, error) {
+	return j.ch, nil
+}
+
+func (j *fakeJob) calls() (runs, cancels int) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	return j.runs, j.cancels
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+type testService struct {
+	*booking.BookingService
+	repo *inmemory.Storage
+}
+
+func newTestService(t *testing.T, jobs ...booking.Job) testService {
+	return newTestServiceWithConfig(t, &config.Config{Booking: config.Booking{IdleReservationTimeout: time.Minute}}, jobs...)
+}
+
+func newTestServiceWithConfig(t *testing.T, cnf *config.Config, jobs ...booking.Job) testService {
+	return newTestServiceWithRepo(t, cnf, func(repo *inmemory.Storage) booking.Repository { return repo }, jobs...)
+}
+
+// newTestServiceWithRepo runs service over repository which wraps test storage
+func newTestServiceWithRepo(t *testing.T, cnf *config.Config, wrap func(repo *inmemory.Storage) booking.Repository, jobs ...booking.Job) testService {
+	repo := inmemory.NewStorage().WithRoomAvailability([]*inmemory.RoomAvailability{
+		{HotelID: testHotelID, RoomType: "eco", Date: testDate, Quota: 1},
+	})
+	wrapped := wrap(repo)
+	s := booking.NewBookingService(cnf, wrapped, booking.NewReservationOrchestrator(wrapped, queue.NewDelayedQueue[booking.StepDeadline](), booking.NewConfigPlanResolver(cnf), jobs...), queue.NewDelayedQueue[string]())
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+
+	return testService{BookingService: s, repo: repo}
+}
+
+func newRequest(id string) *booking.ReservationRequest {
+	return &booking.ReservationRequest{
+		ID:           id,
+		UserID:       "user",
+		HotelID:      testHotelID,
+		RoomsRequest: booking.RoomsRequest{{RoomType: "eco", Count: 1}},
+		PaymentType:  "cash",
+		StartDate:    testDate,
+		EndDate:      testDate,
+	}
+}
+
+func (s testService) freeRooms(t *testing.T) uint {
+	rooms, err := s.repo.GetRoomsByDates(context.Background(), testHotelID, testDate, testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) == 0 {
+		return 0
+	}
+	return rooms[0].FreeCount
+}
+
+func TestBookingService_CancelReservation(t *testing.T) {
+	price := newFakeJob("price", boolPtr(true))
+	// payment waits for asynchronous result
+	payment := newFakeJob("payment", nil)
+	notification := newFakeJob("notification", boolPtr(true))
+
+	s := newTestService(t, price, payment, notification)
+
+	if _, err := s.CreateReservation(context.Background(), "user", newRequest("1")); err != nil {
+		t.Fatal(err)
+	}
+	if s.freeRooms(t) != 0 {
+		t.Fatal("quota is not taken")
+	}
+
+	if _, err := s.CancelReservation(context.Background(), "another user", "1"); !errors.Is(err, booking.ErrNotOwner) {
+		t.Errorf("CancelReservation() error = %v, want %v", err, booking.ErrNotOwner)
+	}
+	if _, err := s.CancelReservation(context.Background(), "user", "404"); !errors.Is(err, booking.ErrNotFound) {
+		t.Errorf("CancelReservation() error = %v, want %v", err, booking.ErrNotFound)
+	}
+
+	r, err := s.CancelReservation(context.Background(), "user", "1")
+	if err != nil {
+		t.Fatalf("CancelReservation() error = %v", err)
+	}
+	if r.Status != booking.CanceledReservationStatus {
+		t.Errorf("status = %s, want %s", r.Status, booking.CanceledReservationStatus)
+	}
+	if s.freeRooms(t) != 1 {
+		t.Error("quota is not released")
+	}
+
+	// notification was not run, so it must not be compensated
+	for _, j := range []*fakeJob{price, payment, notification} {
+		runs, cancels := j.calls()
+		if cancels != runs {
+			t.Errorf("job %s was run %d times and canceled %d times", j.name, runs, cancels)
+		}
+	}
+
+	if _, err := s.CancelReservation(context.Background(), "user", "1"); !errors.Is(err, booking.ErrAlreadyCanceled) {
+		t.Errorf("CancelReservation() error = %v, want %v", err, booking.ErrAlreadyCanceled)
+	}
+
+	history, err := s.GetReservationHistory(context.Background(), "user", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []booking.SagaLogEntry{
+		{Step: "price", Action: booking.RunSagaAction, Outcome: booking.SucceededSagaOutcome},
+		{Step: "payment", Action: booking.RunSagaAction, Outcome: booking.PendingSagaOutcome},
+		{Step: "payment", Action: booking.CancelSagaAction, Outcome: booking.SucceededSagaOutcome},
+		{Step: "price", Action: booking.CancelSagaAction, Outcome: booking.SucceededSagaOutcome},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("GetReservationHistory() returned %d entries, want %d", len(history), len(want))
+	}
+	for i, entry := range history {
+		if entry.Step != want[i].Step || entry.Action != want[i].Action || entry.Outcome != want[i].Outcome {
+			t.Errorf("history[%d] = %s %s %s, want %s %s %s", i, entry.Step, entry.Action, entry.Outcome, want[i].Step, want[i].Action, want[i].Outcome)
+		}
+	}
+
+	if _, err := s.GetReservationHistory(context.Background(), "another user", "1"); !errors.Is(err, booking.ErrNotOwner) {
+		t.Errorf("GetReservationHistory() error = %v, want %v", err, booking.ErrNotOwner)
+	}
+}
+
+func TestBookingService_ChangePaymentMethod(t *testing.T) {
+	payment := newFakeJob("payment", nil)
+
+	s := newTestService(t, payment)
+
+	if _, err := s.CreateReservation(context.Background(), "user", newRequest("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ChangePaymentMethod(context.Background(), "another user", "1", "card", nil); !errors.Is(err, booking.ErrNotOwner) {
+		t.Errorf("ChangePaymentMethod() error = %v, want %v", err, booking.ErrNotOwner)
+	}
+
+	r, err := s.ChangePaymentMethod(context.Background(), "user", "1", "card", nil)
+	if err != nil {
+		t.Fatalf("ChangePaymentMethod() error = %v", err)
+	}
+	if r.PaymentType != "card" || r.Status != "payment" {
+		t.Errorf("reservation = %+v, want card payment in progress", r)
+	}
+
+	runs, cancels := payment.calls()
+	if runs != 2 || cancels != 1 || payment.lastRun.PaymentType != "card" {
+		t.Errorf("payment job was run %d times and canceled %d times, last run with %s", runs, cancels, payment.lastRun.PaymentType)
+	}
+
+	r.Status = booking.FinishedReservationStatus
+	if err := s.repo.UpdateReservation(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ChangePaymentMethod(context.Background(), "user", "1", "cash", nil); !errors.Is(err, booking.ErrAlreadyFinished) {
+		t.Errorf("ChangePaymentMethod() error = %v, want %v", err, booking.ErrAlreadyFinished)
+	}
+}
+
+func TestBookingService_SubscribeOnStatusChanges(t *testing.T) {
+	payment := newFakeJob("payment", nil)
+
+	s := newTestService(t, payment)
+
+	if _, err := s.CreateReservation(context.Background(), "user", newRequest("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := s.SubscribeOnStatusChanges(context.Background(), "another user", "1"); !errors.Is(err, booking.ErrNotOwner) {
+		t.Errorf("SubscribeOnStatusChanges() error = %v, want %v", err, booking.ErrNotOwner)
+	}
+
+	r, changes, unsubscribe, err := s.SubscribeOnStatusChanges(context.Background(), "user", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	if r.Status != "payment" {
+		t.Errorf("status = %s, want payment", r.Status)
+	}
+
+	// payment result comes asynchronously
+	payment.ch <- booking.JobResponse{ReservationID: "1", IsSucceeded: true, JobName: "payment", UpdateData: func(*booking.Reservation) {}}
+
+	for _, want := range []booking.ReservationStatus{"payment", booking.FinishedReservationStatus} {
+		select {
+		case change := <-changes:
+			if change.Status != want {
+				t.Errorf("status change = %s, want %s", change.Status, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("status change to %s is not published", want)
+		}
+	}
+}
+
+func TestBookingService_RetryTransientJobError(t *testing.T) {
+	price := newFakeJob("price", boolPtr(true))
+	price.runErr = booking.WrapError(booking.ErrTransient, "price service is unavailable")
+	price.failRuns = 2
+	notification := newFakeJob("notification", boolPtr(true))
+
+	cnf := &config.Config{Booking: config.Booking{
+		IdleReservationTimeout: time.Minute,
+		Retry: config.Retry{
+			Default: config.RetryPolicy{MaxAttempts: 1},
+			Jobs: map[string]config.RetryPolicy{
+				"price": {MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 10, Multiplier: 2, RetryOn: booking.RetryOnTransient},
+			},
+		},
+	}}
+
+	s := newTestServiceWithConfig(t, cnf, price, notification)
+
+	if _, err := s.CreateReservation(context.Background(), "user", newRequest("1")); err != nil {
+		t.Fatalf("CreateReservation() error = %v, transient error must be retried", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		r, err := s.GetUserReservation(context.Background(), "user", "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status == booking.FinishedReservationStatus {
+			if r.StepAttempts["price"] != 3 || r.StepAttempts["notification"] != 1 {
+				t.Errorf("StepAttempts = %v, want 3 price runs and 1 notification run", r.StepAttempts)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reservation is not finished after retries, status %s", r.Status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// not transient error is not retried, configured failure action is applied
+	for _, action := range []booking.FailureAction{booking.CompensateFailureAction, booking.AwaitUserFailureAction} {
+		t.Run(string(action), func(t *testing.T) {
+			notification := newFakeJob("notification", boolPtr(true))
+			notification.runErr = errors.New("invalid request")
+
+			cnf := &config.Config{Booking: config.Booking{
+				IdleReservationTimeout: time.Minute,
+				Retry: config.Retry{
+					Default: config.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 10, Multiplier: 2, RetryOn: booking.RetryOnTransient},
+				},
+				Failure: config.Failure{Default: string(action)},
+			}}
+
+			s := newTestServiceWithConfig(t, cnf, notification)
+
+			r, err := s.CreateReservation(context.Background(), "user", newRequest("2"))
+			if err != nil {
+				t.Fatalf("CreateReservation() error = %v, failure must be handled by %s action", err, action)
+			}
+			if runs, _ := notification.calls(); runs != 1 {
+				t.Errorf("notification job was run %d times, not transient error must not be retried", runs)
+			}
+			if r.Failure == nil || r.Failure.Step != "notification" || r.Failure.Action != action {
+				t.Fatalf("failure = %+v, want %s of notification step", r.Failure, action)
+			}
+			wantStatus := booking.ReservationStatus("notification")
+			if action == booking.CompensateFailureAction {
+				wantStatus = booking.CanceledReservationStatus
+			}
+			if r.Status != wantStatus {
+				t.Errorf("reservation status = %s, want %s", r.Status, wantStatus)
+			}
+		})
+	}
+}
+
+func TestBookingService_CompensateFailedStep(t *testing.T) {
+	price := newFakeJob("price", boolPtr(true))
+	payment := newFakeJob("payment", boolPtr(false))
+	notification := newFakeJob("notification", boolPtr(true))
+
+	s := newTestService(t, price, payment, notification)
+
+	r, err := s.CreateReservation(context.Background(), "user", newRequest("1"))
+	if err != nil {
+		t.Fatalf("CreateReservation() error = %v", err)
+	}
+	if r.Status != booking.CanceledReservationStatus || r.Failure == nil ||
+		r.Failure.Step != "payment" || r.Failure.Action != booking.CompensateFailureAction {
+		t.Errorf("reservation = %+v, failure = %+v, want canceled after payment failure", r, r.Failure)
+	}
+	if s.freeRooms(t) != 1 {
+		t.Error("quota is not released")
+	}
+	if _, cancels := price.calls(); cancels != 1 {
+		t.Errorf("price job was canceled %d times, want 1", cancels)
+	}
+	if runs, _ := notification.calls(); runs != 0 {
+		t.Errorf("notification job was run %d times after failure", runs)
+	}
+}
+
+func TestBookingService_AwaitUserOnFailedStep(t *testing.T) {
+	payment := newFakeJob("payment", nil)
+
+	cnf := &config.Config{Booking: config.Booking{
+		IdleReservationTimeout: time.Minute,
+		Failure:                config.Failure{Default: "compensate", Jobs: map[string]string{"payment": "await_user"}},
+	}}
+
+	s := newTestServiceWithConfig(t, cnf, payment)
+
+	if _, err := s.CreateReservation(context.Background(), "user", newRequest("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, changes, unsubscribe, err := s.SubscribeOnStatusChanges(context.Background(), "user", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	// payment is declined asynchronously
+	payment.ch <- booking.JobResponse{ReservationID: "1", IsSucceeded: false, JobName: "payment", UpdateData: func(*booking.Reservation) {}}
+
+	for {
+		select {
+		case change := <-changes:
+			if change.Failure == nil {
+				continue
+			}
+			if change.Status != "payment" || change.Failure.Action != booking.AwaitUserFailureAction {
+				t.Fatalf("reservation = %+v, failure = %+v, want it waiting on payment step", change, change.Failure)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("failure is not published")
+		}
+		break
+	}
+
+	if s.freeRooms(t) != 0 {
+		t.Error("quota of reservation waiting for user is released")
+	}
+
+	r, err := s.ChangePaymentMethod(context.Background(), "user", "1", "card", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Failure != nil {
+		t.Errorf("failure = %+v, must be cleared after payment method change", r.Failure)
+	}
+}
+
+// deadlineJob is fake job which declares deadline of pending run
+type deadlineJob struct {
+	*fakeJob
+	deadline time.Duration
+}
+
+func (j deadlineJob) Deadline() time.Duration {
+	return j.deadline
+}
+
+func waitForStatus(t *testing.T, s testService, id string, status booking.ReservationStatus) *booking.Reservation {
+	deadline := time.Now().Add(time.Second)
+	for {
+		r, err := s.GetUserReservation(context.Background(), "user", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status == status {
+			return r
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reservation status = %s, want %s", r.Status, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBookingService_StepDeadline(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeout    config.Timeout
+		wantRuns   int
+		wantCancel int
+	}{
+		{name: "rollback", timeout: config.Timeout{Default: "rollback"}, wantRuns: 1, wantCancel: 1},
+		// second run times out as well, then it is rolled back
+		{name: "retry", timeout: config.Timeout{Default: "rollback", Jobs: map[string]string{"payment": "retry"}}, wantRuns: 2, wantCancel: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment := deadlineJob{fakeJob: newFakeJob("payment", nil), deadline: time.Millisecond * 10}
+
+			cnf := &config.Config{Booking: config.Booking{
+				IdleReservationTimeout: time.Minute,
+				Retry:                  config.Retry{Default: config.RetryPolicy{MaxAttempts: 2}},
+				Timeout:                tt.timeout,
+			}}
+
+			s := newTestServiceWithConfig(t, cnf, payment)
+
+			if _, err := s.CreateReservation(context.Background(), "user", newRequest("1")); err != nil {
+				t.Fatal(err)
+			}
+
+			r := waitForStatus(t, s, "1", booking.CanceledReservationStatus)
+			if r.Failure == nil || r.Failure.Reason != booking.TimedOutFailureReason || r.Failure.Step != "payment" {
+				t.Errorf("failure = %+v, want payment timed out", r.Failure)
+			}
+			if s.freeRooms(t) != 1 {
+				t.Error("quota is not released")
+			}
+
+			if runs, cancels := payment.calls(); runs != tt.wantRuns || cancels != tt.wantCancel {
+				t.Errorf("payment job was run %d times and canceled %d times, want %d and %d", runs, cancels, tt.wantRuns, tt.wantCancel)
+			}
+		})
+	}
+}
+
+func TestBookingService_Plans(t *testing.T) {
+	price := newFakeJob("price", boolPtr(true))
+	payment := newFakeJob("payment", nil)
+
+	cnf := &config.Config{Booking: config.Booking{
+		IdleReservationTimeout: time.Minute,
+		Plans: config.Plans{
+			Named: map[string][]config.PlanStep{"corporate": {{"price"}}},
+			Users: map[string]string{"user": "corporate"},
+		},
+	}}
+
+	s := newTestServiceWithConfig(t, cnf, price, payment)
+
+	r, err := s.CreateReservation(context.Background(), "user", newRequest("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != booking.FinishedReservationStatus || len(r.Plan) != 1 || r.Plan[0].Name() != "price" {
+		t.Errorf("reservation = %+v, want finished with price only plan", r)
+	}
+	if runs, _ := payment.calls(); runs != 0 {
+		t.Errorf("payment job was run %d times, it is not in plan", runs)
+	}
+
+	// saved plan is used even if user's plan is changed
+	delete(cnf.Booking.Plans.Users, "user")
+	if _, err := s.CancelReservation(context.Background(), "user", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, cancels := payment.calls(); cancels != 0 {
+		t.Errorf("payment job was canceled %d times, it is not in plan", cancels)
+	}
+}
+
+func TestBookingService_ParallelSteps(t *testing.T) {
+	tests := []struct {
+		name         string
+		loyaltyRun   *bool
+		wantStatus   booking.ReservationStatus
+		wantSteps    map[booking.ReservationStatus]booking.StepStatus
+		wantCancels  map[booking.ReservationStatus]int
+		wantFailStep booking.ReservationStatus
+	}{
+		{
+			name:       "all succeeded",
+			loyaltyRun: boolPtr(true),
+			wantStatus: booking.FinishedReservationStatus,
+			wantSteps: map[booking.ReservationStatus]booking.StepStatus{
+				"price": booking.SucceededStepStatus, "notification": booking.SucceededStepStatus, "loyalty": booking.SucceededStepStatus,
+			},
+			wantCancels: map[booking.ReservationStatus]int{"price": 0, "notification": 0, "loyalty": 0},
+		},
+		{
+			// declined loyalty has nothing to cancel, only started jobs are compensated
+			name:       "one declined",
+			loyaltyRun: boolPtr(false),
+			wantStatus: booking.CanceledReservationStatus,
+			wantSteps: map[booking.ReservationStatus]booking.StepStatus{
+				"price": booking.CanceledStepStatus, "notification": booking.CanceledStepStatus, "loyalty": booking.FailedStepStatus,
+			},
+			wantCancels:  map[booking.ReservationStatus]int{"price": 1, "notification": 1, "loyalty": 0},
+			wantFailStep: "notification+loyalty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := map[booking.ReservationStatus]*fakeJob{
+				"price":        newFakeJob("price", boolPtr(true)),
+				"notification": newFakeJob("notification", boolPtr(true)),
+				"loyalty":      newFakeJob("loyalty", tt.loyaltyRun),
+			}
+
+			cnf := &config.Config{Booking: config.Booking{
+				IdleReservationTimeout: time.Minute,
+				Plans:                  config.Plans{Default: []config.PlanStep{{"price"}, {"notification", "loyalty"}}},
+			}}
+
+			s := newTestServiceWithConfig(t, cnf, jobs["price"], jobs["notification"], jobs["loyalty"])
+
+			if _, err := s.CreateReservation(context.Background(), "user", newRequest("1")); err != nil {
+				t.Fatal(err)
+			}
+
+			r := waitForStatus(t, s, "1", tt.wantStatus)
+			for name, status := range tt.wantSteps {
+				if r.Steps[name] != status {
+					t.Errorf("step %s status = %s, want %s", name, r.Steps[name], status)
+				}
+			}
+			for name, want := range tt.wantCancels {
+				if runs, cancels := jobs[name].calls(); runs != 1 || cancels != want {
+					t.Errorf("%s job was run %d times and canceled %d times, want 1 and %d", name, runs, cancels, want)
+				}
+			}
+			if tt.wantFailStep != "" && (r.Failure == nil || r.Failure.Step != tt.wantFailStep) {
+				t.Errorf("failure = %+v, want failure of %s", r.Failure, tt.wantFailStep)
+			}
+		})
+	}
+}
+
+type traceKey struct{}
+
+func TestBookingService_Context(t *testing.T) {
+	job := newFakeJob("price", boolPtr(true))
+	s := newTestService(t, job)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.CreateReservation(ctx, "user", newRequest("1")); !errors.Is(err, context.Canceled) {
+		t.Errorf("CreateReservation() error = %v, want context.Canceled", err)
+	}
+	if s.freeRooms(t) != 1 {
+		t.Error("quota is taken by canceled request")
+	}
+
+	ctx = context.WithValue(context.Background(), traceKey{}, "trace")
+	if _, err := s.CreateReservation(ctx, "user", newRequest("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	job.mux.Lock()
+	defer job.mux.Unlock()
+	if job.lastCtx == nil || job.lastCtx.Value(traceKey{}) != "trace" {
+		t.Error("request context does not reach job")
+	}
+}
+
+func TestBookingService_GeneratedID(t *testing.T) {
+	s := newTestService(t, newFakeJob("price", boolPtr(true)))
+
+	r, err := s.CreateReservation(context.Background(), "user", newRequest(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(r.ID) {
+		t.Errorf("reservation id = %q, want uuid v7", r.ID)
+	}
+	if _, err := s.GetUserReservation(context.Background(), "user", r.ID); err != nil {
+		t.Errorf("reservation is not saved by generated id: %v", err)
+	}
+
+	// ids are sorted by creation time
+	prev := booking.NewReservationID()
+	time.Sleep(time.Millisecond * 2)
+	if next := booking.NewReservationID(); next <= prev {
+		t.Errorf("id %s is generated after %s, but it is not greater", next, prev)
+	}
+}
+
+// concurrentRepo changes reservation right before its update when armed, like another instance of service would do
+type concurrentRepo struct {
+	*inmemory.Storage
+	armed atomic.Bool
+}
+
+func (r *concurrentRepo) UpdateReservation(ctx context.Context, reservation *booking.Reservation) error {
+	if r.armed.CompareAndSwap(true, false) {
+		stored, err := r.Storage.GetReservationByID(ctx, reservation.ID)
+		if err != nil {
+			return err
+		}
+		stored.LastUpdateTime = time.Now()
+		if err := r.Storage.UpdateReservation(ctx, stored); err != nil {
+			return err
+		}
+	}
+	return r.Storage.UpdateReservation(ctx, reservation)
+}
+
+func TestBookingService_RetryOnConcurrentModification(t *testing.T) {
+	payment := newFakeJob("payment", nil)
+	repo := &concurrentRepo{}
+
+	cnf := &config.Config{Booking: config.Booking{IdleReservationTimeout: time.Minute}}
+	s := newTestServiceWithRepo(t, cnf, func(storage *inmemory.Storage) booking.Repository {
+		repo.Storage = storage
+		return repo
+	}, payment)
+
+	if _, err := s.CreateReservation(context.Background(), "user", newRequest("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := s.GetUserReservation(context.Background(), "user", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.armed.Store(true)
+	payment.ch <- booking.JobResponse{ReservationID: "1", IsSucceeded: true, UpdateData: func(*booking.Reservation) {}, JobName: "payment"}
+
+	// job result is applied to fresh copy of reservation
+	waitForStatus(t, s, "1", booking.FinishedReservationStatus)
+
+	stale.Status = booking.CanceledReservationStatus
+	if err := s.repo.UpdateReservation(context.Background(), stale); !errors.Is(err, booking.ErrConcurrentModification) {
+		t.Errorf("UpdateReservation() of stale copy error = %v, want %v", err, booking.ErrConcurrentModification)
+	}
+}
+
+func TestBookingService_RelayOutboxOnStart(t *testing.T) {
+	payment := newFakeJob("payment", boolPtr(true))
+	command := &booking.OutboxMessage{ID: "command", ReservationID: "1", Step: "payment", Attempt: 1, CreatedAt: time.Now()}
+
+	// service stopped after command was saved, but before result of payment was saved
+	s := newTestServiceWithRepo(t, &config.Config{Booking: config.Booking{IdleReservationTimeout: time.Minute}}, func(repo *inmemory.Storage) booking.Repository {
+		ctx := context.Background()
+		r, err := repo.CreateReservation(ctx, newRequest("1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Status = "payment"
+		r.StepAttempts = map[booking.ReservationStatus]int{"payment": 1}
+		if err := repo.UpdateReservationWithOutbox(ctx, r, []*booking.OutboxMessage{command}, nil); err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}, payment)
+
+	waitForStatus(t, s, "1", booking.FinishedReservationStatus)
+
+	payment.mux.Lock()
+	commandID := booking.CommandID(payment.lastCtx)
+	payment.mux.Unlock()
+
+	if runs, _ := payment.calls(); runs != 1 || commandID != command.ID {
+		t.Errorf("payment is run %d times by command %q, want once by %q", runs, commandID, command.ID)
+	}
+
+	messages, err := s.repo.GetOutboxMessages(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("outbox has %d messages after saga is finished, want 0", len(messages))
+	}
+}
+
+func TestBookingService_RelayOutboxPeriodically(t *testing.T) {
+	payment := newFakeJob("payment", boolPtr(true))
+	cnf := &config.Config{Booking: config.Booking{IdleReservationTimeout: time.Minute, OutboxRelayInterval: 10 * time.Millisecond}}
+	s := newTestServiceWithConfig(t, cnf, payment)
+
+	// command is left in outbox while service is running, e.g. request which ran it was canceled
+	ctx := context.Background()
+	r, err := s.repo.CreateReservation(ctx, newRequest("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Status = "payment"
+	r.StepAttempts = map[booking.ReservationStatus]int{"payment": 1}
+	command := &booking.OutboxMessage{ID: "command", ReservationID: "1", Step: "payment", Attempt: 1, CreatedAt: time.Now()}
+	if err := s.repo.UpdateReservationWithOutbox(ctx, r, []*booking.OutboxMessage{command}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForStatus(t, s, "1", booking.FinishedReservationStatus)
+
+	if runs, _ := payment.calls(); runs != 1 {
+		t.Errorf("payment is run %d times, want once", runs)
+	}
+}
+
+func TestBookingService_CancelFinishedReservationByJob(t *testing.T) {
+	price := newFakeJob("price", boolPtr(true))
+	payment := newFakeJob("payment", boolPtr(true))
+	s := newTestService(t, price, payment)
+
+	if _, err := s.CreateReservation(context.Background(), "user", newRequest("1")); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, s, "1", booking.FinishedReservationStatus)
+
+	// e.g. installment of payment schedule is not paid after saga is finished
+	payment.ch <- booking.JobResponse{
+		ReservationID:     "1",
+		UpdateData:        func(r *booking.Reservation) { r.AppliedDiscountIDs = []string{"installment"} },
+		JobName:           "payment",
+		CancelReservation: true,
+	}
+
+	r := waitForStatus(t, s, "1", booking.CanceledReservationStatus)
+	if len(r.AppliedDiscountIDs) != 1 {
+		t.Errorf("applied discounts = %v, want data of job response saved", r.AppliedDiscountIDs)
+	}
+	if s.freeRooms(t) != 1 {
+		t.Error("quota is not released")
+	}
+	for _, j := range []*fakeJob{price, payment} {
+		if _, cancels := j.calls(); cancels != 1 {
+			t.Errorf("%s job was canceled %d times, want once", j.name, cancels)
+		}
+	}
+}
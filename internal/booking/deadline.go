@@ -0,0 +1,150 @@
+package booking
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+)
+
+// TimedOutFailureReason is reason of failure of job which has not responded before its deadline
+const TimedOutFailureReason = "timed_out"
+
+// TimeoutAction is what orchestrator does when pending job misses its deadline
+type TimeoutAction string
+
+const (
+	// RollbackTimeoutAction cancels timed out job and compensates reservation
+	RollbackTimeoutAction TimeoutAction = "rollback"
+	// RetryTimeoutAction cancels timed out job and runs it again while retry policy allows it
+	RetryTimeoutAction TimeoutAction = "retry"
+)
+
+// DeadlineJob is job which declares how long orchestrator waits for its asynchronous result.
+// pending jobs which don't declare deadline wait for orchestrator's default timeout
+type DeadlineJob interface {
+	Deadline() time.Duration
+}
+
+// StepDeadline is message of deadline queue. attempt tells apart deadlines of runs of the same step
+type StepDeadline struct {
+	ReservationID string            `json:"reservation_id"`
+	Step          ReservationStatus `json:"step"`
+	Attempt       int               `json:"attempt"`
+}
+
+// DeadlineQueue is delayed queue of pending steps deadlines
+type DeadlineQueue interface {
+	SendMessage(message StepDeadline, delay time.Duration) error
+	Subscribe() <-chan StepDeadline
+	Ack(message StepDeadline) error
+	Cancel(message StepDeadline) error
+}
+
+// timeoutActionsFromConfig returns action for every job which has its own settings
+// and action for all others by empty name
+func timeoutActionsFromConfig(cnf config.Timeout) map[ReservationStatus]TimeoutAction {
+	res := map[ReservationStatus]TimeoutAction{}
+	if cnf.Default != "" {
+		res[""] = TimeoutAction(cnf.Default)
+	}
+	for name, action := range cnf.Jobs {
+		if action != "" {
+			res[ReservationStatus(name)] = TimeoutAction(action)
+		}
+	}
+	return res
+}
+
+func (s *ReservationOrchestrator) timeoutAction(jobName ReservationStatus) TimeoutAction {
+	if action, ok := s.timeoutActions[jobName]; ok {
+		return action
+	}
+	if action, ok := s.timeoutActions[""]; ok {
+		return action
+	}
+	return RollbackTimeoutAction
+}
+
+func (s *ReservationOrchestrator) stepDeadline(r *Reservation) StepDeadline {
+	return StepDeadline{ReservationID: r.ID, Step: r.Status, Attempt: r.StepAttempts[r.Status]}
+}
+
+// waitForResult starts tracking deadline of current pending step of reservation.
+// group waits for the latest deadline of its pending jobs
+func (s *ReservationOrchestrator) waitForResult(r *Reservation, step plannedStep) error {
+	deadline := time.Duration(0)
+	for _, job := range step.jobs {
+		if r.Steps[job.Name()] != PendingStepStatus {
+			continue
+		}
+		jobDeadline := s.timeout
+		if j, ok := job.(DeadlineJob); ok && j.Deadline() > 0 {
+			jobDeadline = j.Deadline()
+		}
+		if jobDeadline > deadline {
+			deadline = jobDeadline
+		}
+	}
+	if deadline <= 0 {
+		return nil
+	}
+	return s.deadlineQueue.SendMessage(s.stepDeadline(r), deadline)
+}
+
+// consumeDeadlines applies timeout action to steps which are still pending after their deadline
+func (s *ReservationOrchestrator) consumeDeadlines() {
+	go func() {
+		ctx := s.ctx
+		deadlines := s.deadlineQueue.Subscribe()
+		for {
+			select {
+			case d := <-deadlines:
+				// deadline is acknowledged only when it is handled, otherwise queue will deliver it again
+				err := s.retryOnConflict(ctx, d.ReservationID, func(r *Reservation) error {
+					return s.handleDeadline(ctx, r, d)
+				})
+				if err != nil && !errors.Is(err, ErrNotFound) {
+					break
+				}
+				_ = s.deadlineQueue.Ack(d)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *ReservationOrchestrator) handleDeadline(ctx context.Context, r *Reservation, d StepDeadline) error {
+	// step has already failed or was run again
+	if r.Status != d.Step || r.StepAttempts[d.Step] != d.Attempt || r.Failure != nil {
+		return nil
+	}
+
+	step, ok := s.currentStep(ctx, r)
+	// step has already responded
+	if !ok || s.joinStep(r, step) != nil {
+		return nil
+	}
+
+	if s.timeoutAction(d.Step) == RetryTimeoutAction && s.retryPolicy(d.Step).MaxAttempts > d.Attempt {
+		// pending runs are not needed anymore, e.g. payment order has to be canceled before new one.
+		// succeeded jobs of group are not run again
+		for _, job := range step.jobs {
+			if r.Steps[job.Name()] != PendingStepStatus {
+				continue
+			}
+			isCanceled, err := job.Cancel(ctx, r)
+			s.logStep(ctx, r, job.Name(), CancelSagaAction, isCanceled, err)
+			if err != nil {
+				return err
+			}
+			r.setStepStatus(job.Name(), CanceledStepStatus)
+		}
+		return s.execute(ctx, r, false)
+	}
+
+	// compensation cancels timed out job as well
+	return s.failWith(ctx, r, d.Step, CompensateFailureAction, TimedOutFailureReason)
+}
@@ -0,0 +1,80 @@
+package booking
+
+import "sync"
+
+// statusSubscriptionBuffer is count of status changes kept for slow subscriber.
+// changes which don't fit are dropped, but final change is always delivered
+const statusSubscriptionBuffer = 16
+
+// statusBroker delivers reservation status changes to subscribers of this reservation
+type statusBroker struct {
+	mux         sync.Mutex
+	subscribers map[string]map[chan Reservation]struct{}
+}
+
+func newStatusBroker() *statusBroker {
+	return &statusBroker{subscribers: map[string]map[chan Reservation]struct{}{}}
+}
+
+// subscribe returns channel of reservation copies and function which closes it
+func (b *statusBroker) subscribe(reservationID string) (<-chan Reservation, func()) {
+	ch := make(chan Reservation, statusSubscriptionBuffer)
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.subscribers[reservationID] == nil {
+		b.subscribers[reservationID] = map[chan Reservation]struct{}{}
+	}
+	b.subscribers[reservationID][ch] = struct{}{}
+
+	once := sync.Once{}
+	return ch, func() {
+		once.Do(func() {
+			b.mux.Lock()
+			defer b.mux.Unlock()
+
+			// channel is already closed if final change was published
+			if _, ok := b.subscribers[reservationID][ch]; !ok {
+				return
+			}
+			delete(b.subscribers[reservationID], ch)
+			if len(b.subscribers[reservationID]) == 0 {
+				delete(b.subscribers, reservationID)
+			}
+			close(ch)
+		})
+	}
+}
+
+// publish never blocks, so slow subscriber can not stop reservation processing.
+// final change replaces the oldest one if buffer is full and closes subscription,
+// so subscriber does not wait for changes which never come
+func (b *statusBroker) publish(r *Reservation) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	isFinal := r.Status == FinishedReservationStatus || r.Status == CanceledReservationStatus
+
+	for ch := range b.subscribers[r.ID] {
+		select {
+		case ch <- *r:
+		default:
+			if !isFinal {
+				continue
+			}
+			// publish is the only sender, so there is room after the oldest change is dropped
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- *r
+		}
+		if isFinal {
+			close(ch)
+		}
+	}
+	if isFinal {
+		delete(b.subscribers, r.ID)
+	}
+}
@@ -0,0 +1,29 @@
+package booking
+
+import "testing"
+
+func TestStatusBroker_FinalChangeIsDelivered(t *testing.T) {
+	b := newStatusBroker()
+	changes, unsubscribe := b.subscribe("1")
+	defer unsubscribe()
+
+	// slow subscriber does not read while buffer is overflowed
+	for i := 0; i < statusSubscriptionBuffer*2; i++ {
+		b.publish(&Reservation{ID: "1", Status: "payment"})
+	}
+	b.publish(&Reservation{ID: "1", Status: FinishedReservationStatus})
+
+	var last Reservation
+	count := 0
+	for r := range changes {
+		last = r
+		count++
+	}
+
+	if last.Status != FinishedReservationStatus {
+		t.Errorf("last change status = %s, want %s", last.Status, FinishedReservationStatus)
+	}
+	if count != statusSubscriptionBuffer {
+		t.Errorf("%d changes are received, want %d", count, statusSubscriptionBuffer)
+	}
+}
@@ -0,0 +1,104 @@
+package booking
+
+import (
+	"context"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+)
+
+// FailureAction is what orchestrator does when job fails and is not retried
+type FailureAction string
+
+const (
+	// CompensateFailureAction cancels all done jobs and reservation itself
+	CompensateFailureAction FailureAction = "compensate"
+	// AwaitUserFailureAction keeps reservation on failed step until user changes it, e.g. payment method.
+	// idle reservation is canceled as usual
+	AwaitUserFailureAction FailureAction = "await_user"
+)
+
+// ReservationFailure describes failed step of reservation and what was done with it
+type ReservationFailure struct {
+	Step   ReservationStatus `json:"step"`
+	Action FailureAction     `json:"action"`
+	Reason string            `json:"reason"`
+	Time   time.Time         `json:"time"`
+}
+
+// failureActionsFromConfig returns action for every job which has its own settings
+// and action for all others by empty name
+func failureActionsFromConfig(cnf config.Failure) map[ReservationStatus]FailureAction {
+	res := map[ReservationStatus]FailureAction{}
+	if cnf.Default != "" {
+		res[""] = FailureAction(cnf.Default)
+	}
+	for name, action := range cnf.Jobs {
+		if action != "" {
+			res[ReservationStatus(name)] = FailureAction(action)
+		}
+	}
+	return res
+}
+
+func (s *ReservationOrchestrator) failureAction(jobName ReservationStatus) FailureAction {
+	if action, ok := s.failureActions[jobName]; ok {
+		return action
+	}
+	if action, ok := s.failureActions[""]; ok {
+		return action
+	}
+	return CompensateFailureAction
+}
+
+// fail records failure of reservation step and applies failure action of job.
+// error is returned only if failure could not be handled
+func (s *ReservationOrchestrator) fail(ctx context.Context, r *Reservation, step ReservationStatus, cause error) error {
+	return s.failWith(ctx, r, step, s.failureAction(step), cause.Error())
+}
+
+func (s *ReservationOrchestrator) failWith(ctx context.Context, r *Reservation, step ReservationStatus, action FailureAction, reason string) error {
+	r.Failure = &ReservationFailure{
+		Step:   step,
+		Action: action,
+		Reason: reason,
+		Time:   time.Now(),
+	}
+
+	if action == CompensateFailureAction {
+		return s.compensate(ctx, r)
+	}
+
+	if err := s.repo.UpdateReservation(ctx, r); err != nil {
+		return err
+	}
+	s.onStatusChanged(r)
+
+	return nil
+}
+
+// compensate cancels all done jobs of reservation and releases its rooms quota
+func (s *ReservationOrchestrator) compensate(ctx context.Context, r *Reservation) error {
+	err := s.rollback(ctx, r, false)
+
+	// save compensation results even if some job failed to cancel
+	if err := s.repo.UpdateReservation(ctx, r); err != nil {
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.CancelReservation(ctx, r.ID); err != nil {
+		return err
+	}
+	// cancelation is saved as next version
+	r.Status = CanceledReservationStatus
+	r.Version++
+
+	s.onStatusChanged(r)
+	s.onCompleted(r.ID)
+
+	return nil
+}
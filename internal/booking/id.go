@@ -0,0 +1,48 @@
+package booking
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"regexp"
+	"time"
+)
+
+// reservationIDFormat allows client ids which are safe to use in url path, e.g. uuid or ulid
+var reservationIDFormat = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// IsValidReservationID checks format of client provided reservation id
+func IsValidReservationID(id string) bool {
+	return reservationIDFormat.MatchString(id)
+}
+
+// NewReservationID generates UUIDv7. it starts with unix time in milliseconds,
+// so ids are sorted by creation time, and ends with 74 random bits
+func NewReservationID() string {
+	var id [16]byte
+
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+
+	if _, err := rand.Read(id[6:]); err != nil {
+		panic("reading random bytes: " + err.Error())
+	}
+
+	// version 7 and RFC 4122 variant
+	id[6] = id[6]&0x0f | 0x70
+	id[8] = id[8]&0x3f | 0x80
+
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], id[10:])
+
+	return string(buf)
+}
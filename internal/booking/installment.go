@@ -0,0 +1,60 @@
+package booking
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/money"
+	"github.com/antnmxmv/booking-service/internal/payment"
+)
+
+// InstallmentFailureAction is what happens with reservation when its installment is not paid
+type InstallmentFailureAction string
+
+const (
+	// RefundDepositFailureAction cancels reservation and refunds paid part of cost
+	RefundDepositFailureAction InstallmentFailureAction = "refund"
+	// KeepDepositFailureAction cancels reservation, paid part of cost is kept as penalty
+	KeepDepositFailureAction InstallmentFailureAction = "keep_deposit"
+)
+
+// Installment is part of reservation cost which is charged after saga is finished by payment schedule of hotel
+type Installment struct {
+	Amount  money.Money `json:"amount"`
+	DueDate time.Time   `json:"due_date"`
+	// OnFailure is action agreed at booking
+	OnFailure InstallmentFailureAction `json:"on_failure"`
+	// Order is created on due date
+	Order payment.Order `json:"order,omitempty"`
+}
+
+// installmentOrderSeparator can not be a part of reservation id, see IsValidReservationID
+const installmentOrderSeparator = "/installment/"
+
+// InstallmentOrderID is identifier of installment order at payment source
+func InstallmentOrderID(reservationID string, index int) string {
+	return reservationID + installmentOrderSeparator + strconv.Itoa(index)
+}
+
+// ParseInstallmentOrderID returns reservation id and index of installment of order identifier
+func ParseInstallmentOrderID(orderID string) (reservationID string, index int, ok bool) {
+	reservationID, indexStr, ok := strings.Cut(orderID, installmentOrderSeparator)
+	if !ok {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(indexStr)
+	if err != nil {
+		return "", 0, false
+	}
+	return reservationID, index, true
+}
+
+// Installment returns installment by index of order identifier
+func (r *Reservation) Installment(index int) (*Installment, error) {
+	if index < 0 || index >= len(r.Installments) {
+		return nil, fmt.Errorf("reservation %s has no installment %d", r.ID, index)
+	}
+	return r.Installments[index], nil
+}
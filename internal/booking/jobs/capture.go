@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/payment"
+)
+
+// CaptureMessage is message of capture queue, it is delivered on start date of reservation
+type CaptureMessage struct {
+	ReservationID string `json:"reservation_id"`
+}
+
+// CaptureQueue is delayed queue of scheduled captures of authorized orders
+type CaptureQueue interface {
+	SendMessage(message CaptureMessage, delay time.Duration) error
+	Subscribe() <-chan CaptureMessage
+	Ack(message CaptureMessage) error
+	Cancel(message CaptureMessage) error
+}
+
+// scheduleCapture makes authorized order captured on check-in. saga is finished on authorization
+func (p *PaymentJob) scheduleCapture(r *booking.Reservation) error {
+	delay := time.Until(r.StartDate)
+	if delay < 0 {
+		delay = 0
+	}
+	return p.captureQueue.SendMessage(CaptureMessage{ReservationID: r.ID}, delay)
+}
+
+// consumeCaptures captures orders which are due. not acknowledged capture is delivered again
+func (p *PaymentJob) consumeCaptures() {
+	go func() {
+		for {
+			select {
+			case message := <-p.captureQueue.Subscribe():
+				if err := p.capture(p.ctx, message.ReservationID); err != nil {
+					log.Printf("[payment] capture of reservation %s failed: %s", message.ReservationID, err.Error())
+					continue
+				}
+				_ = p.captureQueue.Ack(message)
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (p *PaymentJob) capture(ctx context.Context, reservationID string) error {
+	r, err := p.repo.GetReservationByID(ctx, reservationID)
+	if errors.Is(err, booking.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// canceled reservation has voided order
+	if r.Status == booking.CanceledReservationStatus {
+		return nil
+	}
+	// authorization may be not saved yet, capture is delivered again later
+	if r.PaymentOrder == nil || r.PaymentOrder.Status() == payment.PaymentStatusPending {
+		return errors.New("payment order is not authorized yet")
+	}
+
+	// installments which are paid before check-in are captured together with deposit
+	orders := []payment.Order{r.PaymentOrder}
+	for _, installment := range r.Installments {
+		if installment.Order != nil {
+			orders = append(orders, installment.Order)
+		}
+	}
+
+	for _, order := range orders {
+		if order.Status() != payment.PaymentStatusAuthorized {
+			continue
+		}
+
+		captured, err := p.p.CaptureOrder(ctx, r.PaymentType, order, order.ReservationID()+"/capture")
+		if err != nil {
+			return err
+		}
+
+		err = p.saveOrder(ctx, captured, func(stored payment.Order) bool {
+			return stored != nil && stored.Status() == payment.PaymentStatusAuthorized
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/money"
+	"github.com/antnmxmv/booking-service/internal/payment"
+)
+
+// InstallmentMessage is message of installment queue, it is delivered on due date of installment
+type InstallmentMessage struct {
+	ReservationID string `json:"reservation_id"`
+	Index         int    `json:"index"`
+}
+
+// InstallmentQueue is delayed queue of installments of payment schedules
+type InstallmentQueue interface {
+	SendMessage(message InstallmentMessage, delay time.Duration) error
+	Subscribe() <-chan InstallmentMessage
+	Ack(message InstallmentMessage) error
+	Cancel(message InstallmentMessage) error
+}
+
+// splitCost makes installments of reservation by payment schedule of its hotel
+// and returns amount which is paid at booking
+func (p *PaymentJob) splitCost(r *booking.Reservation) money.Money {
+	r.Installments = nil
+
+	schedule, ok := p.cnf.Payment.Schedules[r.HotelID]
+	if !ok || schedule.DepositPercent <= 0 || schedule.DepositPercent >= 100 {
+		return r.Cost
+	}
+
+	dueDate := r.StartDate.Add(-schedule.BalanceBeforeArrival)
+	// it is too late for balance, whole cost is paid at booking
+	if !dueDate.After(time.Now()) {
+		return r.Cost
+	}
+
+	deposit := r.Cost.Percent(schedule.DepositPercent)
+	r.Installments = []*booking.Installment{{
+		Amount:    money.New(r.Cost.Amount-deposit.Amount, r.Cost.Currency),
+		DueDate:   dueDate,
+		OnFailure: booking.InstallmentFailureAction(schedule.OnBalanceFailure),
+	}}
+
+	return deposit
+}
+
+// schedulePayments schedules capture of authorized order and installments after deposit is paid
+func (p *PaymentJob) schedulePayments(r *booking.Reservation, order payment.Order) error {
+	if order.Status() == payment.PaymentStatusAuthorized {
+		if err := p.scheduleCapture(r); err != nil {
+			return err
+		}
+	}
+
+	for i, installment := range r.Installments {
+		if installment.Order != nil {
+			continue
+		}
+		delay := time.Until(installment.DueDate)
+		if delay < 0 {
+			delay = 0
+		}
+		if err := p.installmentQueue.SendMessage(InstallmentMessage{ReservationID: r.ID, Index: i}, delay); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// schedulePaymentsOf schedules payments after order is paid asynchroniously
+func (p *PaymentJob) schedulePaymentsOf(ctx context.Context, order payment.Order) error {
+	r, err := p.repo.GetReservationByID(ctx, order.ReservationID())
+	if err != nil {
+		return err
+	}
+	return p.schedulePayments(r, order)
+}
+
+// consumeInstallments charges installments which are due. not acknowledged installment is delivered again
+func (p *PaymentJob) consumeInstallments() {
+	go func() {
+		for {
+			select {
+			case message := <-p.installmentQueue.Subscribe():
+				if err := p.chargeInstallment(p.ctx, message); err != nil {
+					log.Printf("[payment] installment %d of reservation %s failed: %s", message.Index, message.ReservationID, err.Error())
+					continue
+				}
+				_ = p.installmentQueue.Ack(message)
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// chargeInstallment creates order of installment which is due
+func (p *PaymentJob) chargeInstallment(ctx context.Context, message InstallmentMessage) error {
+	r, err := p.repo.GetReservationByID(ctx, message.ReservationID)
+	if errors.Is(err, booking.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// canceled reservation has nothing to pay, changed one has new schedule
+	if r.Status != booking.FinishedReservationStatus {
+		return nil
+	}
+	installment, err := r.Installment(message.Index)
+	if err != nil || installment.Order != nil {
+		return nil
+	}
+
+	// order id is idempotency key too, so redelivered installment is not charged twice
+	orderID := booking.InstallmentOrderID(r.ID, message.Index)
+	order, err := p.createOrder(ctx, r, orderID, installment.Amount, orderID)
+	if err != nil {
+		return err
+	}
+
+	return p.handleInstallmentOrder(ctx, order)
+}
+
+// handleInstallmentOrder saves state of installment order. not paid installment makes
+// orchestrator cancel reservation, deposit is refunded or kept by failure action of installment
+func (p *PaymentJob) handleInstallmentOrder(ctx context.Context, order payment.Order) error {
+	reservationID, index, _ := booking.ParseInstallmentOrderID(order.ReservationID())
+
+	if order.Status() == payment.PaymentStatusFailed || order.Status() == payment.PaymentStatusCanceled {
+		response := booking.JobResponse{
+			ReservationID: reservationID,
+			UpdateData: func(r *booking.Reservation) {
+				if installment, err := r.Installment(index); err == nil {
+					installment.Order = order
+				}
+			},
+			JobName:           p.Name(),
+			CancelReservation: true,
+		}
+		select {
+		case p.updateCh <- response:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	err := p.saveOrder(ctx, order, func(stored payment.Order) bool {
+		// later refund may be already saved
+		return stored == nil || stored.RefundedAmount().Amount <= order.RefundedAmount().Amount
+	})
+	if err != nil {
+		return err
+	}
+
+	if order.Status() == payment.PaymentStatusAuthorized {
+		// order id is not reservation id, so reservation is loaded by parsed one
+		r, err := p.repo.GetReservationByID(ctx, reservationID)
+		if err != nil {
+			return err
+		}
+		// installment held before check-in is captured together with deposit
+		return p.scheduleCapture(r)
+	}
+	return nil
+}
+
+// cancelInstallments compensates orders of installments. keepDeposit tells that reservation
+// is canceled because installment is not paid and paid part of cost is kept by hotel
+func (p *PaymentJob) cancelInstallments(ctx context.Context, r *booking.Reservation) (done *bool, keepDeposit bool, err error) {
+	allDone := true
+
+	for i, installment := range r.Installments {
+		_ = p.installmentQueue.Cancel(InstallmentMessage{ReservationID: r.ID, Index: i})
+
+		if installment.Order == nil {
+			continue
+		}
+		if status := installment.Order.Status(); status == payment.PaymentStatusFailed || status == payment.PaymentStatusCanceled {
+			keepDeposit = keepDeposit || installment.OnFailure == booking.KeepDepositFailureAction
+			continue
+		}
+
+		order, isCanceled, err := p.compensateOrder(ctx, r.PaymentType, booking.InstallmentOrderID(r.ID, i), installment.Order)
+		installment.Order = order
+		if err != nil {
+			return nil, false, err
+		}
+		allDone = allDone && isCanceled != nil && *isCanceled
+	}
+
+	return &allDone, keepDeposit, nil
+}
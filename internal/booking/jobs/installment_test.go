@@ -0,0 +1,187 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
+	"github.com/antnmxmv/booking-service/internal/payment"
+)
+
+func TestPaymentJob_splitCost(t *testing.T) {
+	cnf := &config.Config{Payment: config.Payment{Schedules: map[string]config.PaymentSchedule{
+		"scheduled": {DepositPercent: 30, BalanceBeforeArrival: time.Hour * 24 * 7, OnBalanceFailure: "keep_deposit"},
+		"wrong":     {DepositPercent: 100, BalanceBeforeArrival: time.Hour * 24 * 7},
+	}}}
+	j := NewPaymentJob(cnf, nil, nil, nil, nil)
+
+	farStart := time.Now().Add(time.Hour * 24 * 30)
+
+	tests := []struct {
+		name        string
+		hotelID     string
+		startDate   time.Time
+		wantDeposit money.Money
+		wantBalance *money.Money
+	}{
+		{name: "no schedule", hotelID: "other", startDate: farStart, wantDeposit: money.New(1001, "EUR")},
+		{name: "deposit and balance", hotelID: "scheduled", startDate: farStart, wantDeposit: money.New(300, "EUR"), wantBalance: &money.Money{Amount: 701, Currency: "EUR"}},
+		{name: "due date is passed", hotelID: "scheduled", startDate: time.Now().Add(time.Hour * 24), wantDeposit: money.New(1001, "EUR")},
+		{name: "deposit is out of range", hotelID: "wrong", startDate: farStart, wantDeposit: money.New(1001, "EUR")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &booking.Reservation{HotelID: tt.hotelID, StartDate: tt.startDate, Cost: money.New(1001, "EUR")}
+
+			if deposit := j.splitCost(r); deposit != tt.wantDeposit {
+				t.Errorf("splitCost() = %s, want %s", deposit, tt.wantDeposit)
+			}
+
+			if tt.wantBalance == nil {
+				if len(r.Installments) != 0 {
+					t.Errorf("installments = %v, want none", r.Installments)
+				}
+				return
+			}
+			if len(r.Installments) != 1 {
+				t.Fatalf("installments = %v, want balance", r.Installments)
+			}
+			balance := r.Installments[0]
+			if balance.Amount != *tt.wantBalance || !balance.DueDate.Equal(tt.startDate.Add(-time.Hour*24*7)) || balance.OnFailure != booking.KeepDepositFailureAction {
+				t.Errorf("balance = %+v, want %s due week before start date", balance, tt.wantBalance)
+			}
+		})
+	}
+}
+
+// orderURL is payment link of card order, every created order has its own
+func orderURL(t *testing.T, order payment.Order) string {
+	data, err := json.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := struct {
+		URL string `json:"url"`
+	}{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res.URL
+}
+
+func TestPaymentJob_chargeInstallment(t *testing.T) {
+	tests := []struct {
+		name string
+		// failUpdates is count of failed saves of installment order
+		failUpdates int
+		wantUpdates int
+	}{
+		{name: "redelivered after save", failUpdates: 0, wantUpdates: 1},
+		{name: "redelivered before save", failUpdates: 1, wantUpdates: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeRepo{
+				r: &booking.Reservation{
+					ID:           "1",
+					Status:       booking.FinishedReservationStatus,
+					PaymentType:  "card",
+					Installments: []*booking.Installment{{Amount: money.New(70, "EUR"), DueDate: time.Now()}},
+				},
+				failUpdates: tt.failUpdates,
+			}
+			j := newTestPaymentJob(&config.Config{}, repo)
+			details, err := j.p.UnmarshalDetailsJSON("card", []byte(`{"card_id":1}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo.r.PaymentRequestDetails = details
+
+			message := InstallmentMessage{ReservationID: "1", Index: 0}
+			for attempt := 0; attempt <= tt.failUpdates+1; attempt++ {
+				err := j.chargeInstallment(context.Background(), message)
+				if (err != nil) != (attempt < tt.failUpdates) {
+					t.Fatalf("delivery %d error = %v", attempt, err)
+				}
+			}
+
+			if len(repo.updates) != tt.wantUpdates {
+				t.Fatalf("reservation is updated %d times, want %d", len(repo.updates), tt.wantUpdates)
+			}
+			url := orderURL(t, repo.updates[0].Installments[0].Order)
+			for _, update := range repo.updates[1:] {
+				if got := orderURL(t, update.Installments[0].Order); got != url {
+					t.Errorf("installment is charged twice, orders %s and %s", url, got)
+				}
+			}
+		})
+	}
+}
+
+// recordingQueue keeps sent messages
+type recordingQueue[T any] struct {
+	fakeQueue[T]
+	sent chan T
+}
+
+func (q recordingQueue[T]) SendMessage(message T, _ time.Duration) error {
+	q.sent <- message
+	return nil
+}
+
+func TestPaymentJob_AuthorizedInstallmentIsCaptured(t *testing.T) {
+	const secret = "secret"
+	cnf := &config.Config{Payment: config.Payment{Card: config.Card{WebhookSecret: secret}}}
+	p := payment.NewPaymentProvider(payment.NewCashSource(), payment.NewCardSource(cnf))
+	repo := &fakeRepo{r: &booking.Reservation{
+		ID:           "1",
+		Status:       booking.FinishedReservationStatus,
+		PaymentType:  "card",
+		StartDate:    time.Now().Add(time.Hour),
+		Installments: []*booking.Installment{{Amount: money.New(70, "EUR"), DueDate: time.Now()}},
+	}}
+	captures := recordingQueue[CaptureMessage]{sent: make(chan CaptureMessage, 1)}
+
+	j := NewPaymentJob(cnf, p, repo, captures, fakeQueue[InstallmentMessage]{})
+	if err := j.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Stop(context.Background())
+
+	details, err := p.UnmarshalDetailsJSON("card", []byte(`{"card_id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.r.PaymentRequestDetails = details
+
+	if err := j.chargeInstallment(context.Background(), InstallmentMessage{ReservationID: "1", Index: 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	// acquirer authorizes installment order asynchronously
+	orderID := booking.InstallmentOrderID("1", 0)
+	payload := []byte(`{"reservation_id":"` + orderID + `","status":"authorized","amount":70,"currency":"EUR"}`)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	if _, err := p.HandleWebhook(context.Background(), "card", payload, timestamp, payment.Sign(secret, timestamp, payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case message := <-captures.sent:
+		if message.ReservationID != "1" {
+			t.Errorf("capture of reservation %s is scheduled, want 1", message.ReservationID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("capture of authorized installment is not scheduled")
+	}
+	if status := repo.r.Installments[0].Order.Status(); status != payment.PaymentStatusAuthorized {
+		t.Errorf("installment order status = %s, want %s", status, payment.PaymentStatusAuthorized)
+	}
+}
@@ -1,6 +1,10 @@
 package jobs
 
-import "github.com/antnmxmv/booking-service/internal/booking"
+import (
+	"context"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+)
 
 type NotificationProviderFacade interface {
 	Notify(any) error
@@ -24,13 +28,13 @@ func (p *NotificationJob) Name() booking.ReservationStatus {
 	return "notification"
 }
 
-func (p *NotificationJob) Run(_ *booking.Reservation) (*bool, error) {
+func (p *NotificationJob) Run(_ context.Context, _ *booking.Reservation) (*bool, error) {
 	// p.api.Notify(reservation.ID)
 	res := true
 	return &res, nil
 }
 
-func (p *NotificationJob) Cancel(_ *booking.Reservation) (*bool, error) {
+func (p *NotificationJob) Cancel(_ context.Context, _ *booking.Reservation) (*bool, error) {
 	// already notified, can not cancel
 	res := false
 	return &res, nil
@@ -2,25 +2,45 @@ package jobs
 
 import (
 	"context"
+	"errors"
+	"log"
+	"time"
 
 	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
 	"github.com/antnmxmv/booking-service/internal/payment"
 )
 
+// maxSaveAttempts is count of attempts to save order when reservation is changed concurrently
+const maxSaveAttempts = 3
+
 // PaymentJob garantees that payment order status changes will affect the reservation state
 type PaymentJob struct {
+	cnf      *config.Config
 	p        *payment.Provider
 	repo     booking.Repository
 	updateCh chan booking.JobResponse
-	doneCh   chan struct{}
+	// captureQueue delivers authorized orders on check-in
+	captureQueue CaptureQueue
+	// installmentQueue delivers installments of payment schedules on due date
+	installmentQueue InstallmentQueue
+	// ctx is canceled on stop
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-func NewPaymentJob(p *payment.Provider, repo booking.Repository) *PaymentJob {
+func NewPaymentJob(cnf *config.Config, p *payment.Provider, repo booking.Repository, captureQueue CaptureQueue, installmentQueue InstallmentQueue) *PaymentJob {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &PaymentJob{
-		p:        p,
-		repo:     repo,
-		updateCh: make(chan booking.JobResponse),
-		doneCh:   make(chan struct{}),
+		cnf:              cnf,
+		p:                p,
+		repo:             repo,
+		updateCh:         make(chan booking.JobResponse),
+		captureQueue:     captureQueue,
+		installmentQueue: installmentQueue,
+		ctx:              ctx,
+		cancel:           cancel,
 	}
 }
 
@@ -28,10 +48,31 @@ func (p *PaymentJob) Name() booking.ReservationStatus {
 	return "payment"
 }
 
-func (p *PaymentJob) Run(req *booking.Reservation) (*bool, error) {
+// Deadline is time to wait for result of pending payment order
+func (p *PaymentJob) Deadline() time.Duration {
+	return p.cnf.Payment.OrderDeadline
+}
+
+// createOrder creates order of reservation. amount is only held in hotels which capture payment
+// on check-in, it is charged at once if payment source can not hold it
+func (p *PaymentJob) createOrder(ctx context.Context, r *booking.Reservation, orderID string, amount money.Money, idempotencyKey string) (payment.Order, error) {
+	if p.cnf.Payment.CaptureOnCheckIn[r.HotelID] {
+		order, err := p.p.AuthorizeOrder(ctx, orderID, amount, r.PaymentType, r.PaymentRequestDetails, idempotencyKey)
+		if !errors.Is(err, payment.ErrCaptureNotSupported) {
+			return order, err
+		}
+	}
+	return p.p.CreateOrder(ctx, orderID, amount, r.PaymentType, r.PaymentRequestDetails, idempotencyKey)
+}
+
+func (p *PaymentJob) Run(ctx context.Context, req *booking.Reservation) (*bool, error) {
 	var isSucceeded *bool
 
-	paymentOrder, err := p.p.CreateOrder(req.ID, req.Cost, req.PaymentType, req.PaymentRequestDetails)
+	// deposit is paid at booking when hotel has payment schedule, the rest is charged later
+	amount := p.splitCost(req)
+
+	// command id is the same when step is relayed after restart, so order is not created twice
+	paymentOrder, err := p.createOrder(ctx, req, req.ID, amount, booking.CommandID(ctx))
 	if err == nil {
 		req.PaymentOrder = paymentOrder
 
@@ -40,28 +81,109 @@ func (p *PaymentJob) Run(req *booking.Reservation) (*bool, error) {
 			// return nil
 			return nil, nil
 		}
+
 		// if order immediately succeeded, we tell orchestrator that payment job is done
-		boolValue := paymentOrder.Status() == payment.PaymentStatusSuccess
+		boolValue := isPaid(paymentOrder)
 		isSucceeded = &boolValue
 		// if order creation failed provide details of unsuccessful operation to orchestrator
 		// assuming that he could change payment type later
+
+		if boolValue {
+			if err := p.schedulePayments(req, paymentOrder); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	return isSucceeded, err
+	return isSucceeded, transientError(err)
 }
 
-func (p *PaymentJob) Cancel(req *booking.Reservation) (*bool, error) {
-	var done *bool
-	order, err := p.p.CancelOrder(req.ID, req.PaymentType)
-	if err == nil {
-		if order.Status() == payment.PaymentStatusPending {
-			return nil, nil
+// transientError marks errors of unavailable payment source, so orchestrator can run job again
+func transientError(err error) error {
+	if errors.Is(err, payment.ErrUnavailable) {
+		return booking.WrapError(booking.ErrTransient, err.Error())
+	}
+	return err
+}
+
+// Cancel cancels pending orders, releases held amounts of authorized ones and refunds the rest of paid ones.
+// deposit is not returned if reservation is canceled because installment is not paid and hotel keeps deposit then
+func (p *PaymentJob) Cancel(ctx context.Context, req *booking.Reservation) (*bool, error) {
+	_ = p.captureQueue.Cancel(CaptureMessage{ReservationID: req.ID})
+
+	installmentsDone, keepDeposit, err := p.cancelInstallments(ctx, req)
+	if err != nil {
+		return nil, transientError(err)
+	}
+	if keepDeposit {
+		// capture on check-in is canceled above, so held deposit is charged now
+		if req.PaymentOrder != nil && req.PaymentOrder.Status() == payment.PaymentStatusAuthorized {
+			captured, err := p.p.CaptureOrder(ctx, req.PaymentType, req.PaymentOrder, req.ID+"/capture")
+			if err != nil {
+				return nil, transientError(err)
+			}
+			req.PaymentOrder = captured
 		}
-		boolValue := order.Status() == payment.PaymentStatusCanceled
-		done = &boolValue
-		req.PaymentOrder = order
+		return installmentsDone, nil
 	}
-	return done, err
+
+	order, done, err := p.compensateOrder(ctx, req.PaymentType, req.ID, req.PaymentOrder)
+	req.PaymentOrder = order
+	if err != nil || done == nil || installmentsDone == nil {
+		return nil, transientError(err)
+	}
+
+	allDone := *done && *installmentsDone
+	return &allDone, nil
+}
+
+// compensateOrder cancels pending order, releases held amount of authorized one and refunds the rest of paid one.
+// orderID is reservation id or installment order id
+func (p *PaymentJob) compensateOrder(ctx context.Context, paymentType payment.SourceType, orderID string, order payment.Order) (payment.Order, *bool, error) {
+	var status payment.PaymentStatus
+	if order != nil {
+		status = order.Status()
+	}
+
+	switch {
+	case status == payment.PaymentStatusAuthorized:
+		voided, err := p.p.VoidOrder(ctx, paymentType, order)
+		if err != nil {
+			return order, nil, err
+		}
+		done := voided.Status() == payment.PaymentStatusCanceled
+		return voided, &done, nil
+	case status == payment.PaymentStatusRefundPending || status == payment.PaymentStatusRefunded:
+		return order, p.refundStatus(order), nil
+	case payment.IsRefundable(status):
+		// key is the same for every attempt of compensation, so money is returned once
+		refund, err := p.p.RefundOrder(ctx, paymentType, order, payment.RefundableAmount(order), orderID+"/refund")
+		if err != nil {
+			return order, nil, err
+		}
+		return refund, p.refundStatus(refund), nil
+	}
+
+	canceled, err := p.p.CancelOrder(ctx, orderID, paymentType)
+	if err != nil || canceled.Status() == payment.PaymentStatusPending {
+		return order, nil, err
+	}
+	done := canceled.Status() == payment.PaymentStatusCanceled
+	return canceled, &done, nil
+}
+
+// isPaid checks if order is enough to finish saga, authorized order is captured later
+func isPaid(order payment.Order) bool {
+	return order.Status() == payment.PaymentStatusSuccess || order.Status() == payment.PaymentStatusAuthorized
+}
+
+// refundStatus is result of compensation by refund, it is pending until refund is completed
+func (p *PaymentJob) refundStatus(order payment.Order) *bool {
+	if order.Status() == payment.PaymentStatusRefundPending {
+		return nil
+	}
+	done := true
+	return &done
 }
 
 func (p *PaymentJob) Subscribe() (<-chan booking.JobResponse, error) {
@@ -75,15 +197,34 @@ func (p *PaymentJob) conumeIncomingChanges() error {
 		for {
 			select {
 			case paymentStatusUpdate := <-updatesCh:
+				if _, _, ok := booking.ParseInstallmentOrderID(paymentStatusUpdate.ReservationID()); ok {
+					if err := p.handleInstallmentOrder(p.ctx, paymentStatusUpdate); err != nil {
+						log.Printf("[payment] installment order %s is not saved: %s", paymentStatusUpdate.ReservationID(), err.Error())
+					}
+					continue
+				}
+				// capture and refund are made after saga, so orchestrator does not wait for them
+				if paymentStatusUpdate.Status() == payment.PaymentStatusCaptured || payment.IsRefundStatus(paymentStatusUpdate.Status()) {
+					_ = p.saveOrder(p.ctx, paymentStatusUpdate, func(stored payment.Order) bool {
+						// later refund may be already saved
+						return stored != nil && stored.RefundedAmount().Amount <= paymentStatusUpdate.RefundedAmount().Amount
+					})
+					continue
+				}
+				if isPaid(paymentStatusUpdate) {
+					if err := p.schedulePaymentsOf(p.ctx, paymentStatusUpdate); err != nil {
+						log.Printf("[payment] payments of reservation %s are not scheduled: %s", paymentStatusUpdate.ReservationID(), err.Error())
+					}
+				}
 				p.updateCh <- booking.JobResponse{
 					ReservationID: paymentStatusUpdate.ReservationID(),
-					IsSucceeded:   paymentStatusUpdate.Status() == payment.PaymentStatusSuccess,
+					IsSucceeded:   isPaid(paymentStatusUpdate),
 					UpdateData: func(r *booking.Reservation) {
 						r.PaymentOrder = paymentStatusUpdate
 					},
 					JobName: p.Name(),
 				}
-			case <-p.doneCh:
+			case <-p.ctx.Done():
 				return
 			}
 		}
@@ -91,15 +232,52 @@ func (p *PaymentJob) conumeIncomingChanges() error {
 	return nil
 }
 
+// saveOrder keeps order changed after saga in reservation if stored order is still the one to change.
+// order of installment is saved in its installment
+func (p *PaymentJob) saveOrder(ctx context.Context, order payment.Order, isActual func(stored payment.Order) bool) error {
+	reservationID, index, isInstallment := booking.ParseInstallmentOrderID(order.ReservationID())
+	if !isInstallment {
+		reservationID = order.ReservationID()
+	}
+
+	for attempt := 0; attempt < maxSaveAttempts; attempt++ {
+		r, err := p.repo.GetReservationByID(ctx, reservationID)
+		if err != nil {
+			return err
+		}
+
+		stored := &r.PaymentOrder
+		if isInstallment {
+			installment, err := r.Installment(index)
+			if err != nil {
+				return err
+			}
+			stored = &installment.Order
+		}
+
+		if !isActual(*stored) {
+			return nil
+		}
+		*stored = order
+		if err := p.repo.UpdateReservation(ctx, r); !errors.Is(err, booking.ErrConcurrentModification) {
+			return err
+		}
+	}
+	return booking.ErrConcurrentModification
+}
+
 func (p *PaymentJob) Start(_ context.Context) error {
 	if err := p.conumeIncomingChanges(); err != nil {
 		return err
 	}
 
+	p.consumeCaptures()
+	p.consumeInstallments()
+
 	return nil
 }
 
 func (p *PaymentJob) Stop(_ context.Context) error {
-	close(p.doneCh)
+	p.cancel()
 	return nil
 }
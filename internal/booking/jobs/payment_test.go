@@ -0,0 +1,107 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
+	"github.com/antnmxmv/booking-service/internal/payment"
+)
+
+// fakeRepo keeps single reservation, the rest of repository is not used by payment job
+type fakeRepo struct {
+	booking.Repository
+	r *booking.Reservation
+	// failUpdates is count of the next updates which fail
+	failUpdates int
+	// updates are all saved or failed updates
+	updates []*booking.Reservation
+}
+
+func (f *fakeRepo) GetReservationByID(_ context.Context, id string) (*booking.Reservation, error) {
+	if f.r == nil || f.r.ID != id {
+		return nil, booking.ErrNotFound
+	}
+	return f.r.Copy(), nil
+}
+
+func (f *fakeRepo) UpdateReservation(_ context.Context, r *booking.Reservation) error {
+	f.updates = append(f.updates, r)
+	if f.failUpdates > 0 {
+		f.failUpdates--
+		return errors.New("storage is unavailable")
+	}
+	f.r = r
+	return nil
+}
+
+// fakeQueue drops all messages
+type fakeQueue[T any] struct{}
+
+func (fakeQueue[T]) SendMessage(T, time.Duration) error { return nil }
+func (fakeQueue[T]) Subscribe() <-chan T                { return nil }
+func (fakeQueue[T]) Ack(T) error                        { return nil }
+func (fakeQueue[T]) Cancel(T) error                     { return nil }
+
+// newTestPaymentJob makes job over card source which waits for webhooks, so orders stay pending
+func newTestPaymentJob(cnf *config.Config, repo booking.Repository) *PaymentJob {
+	cnf.Payment.Card.WebhookSecret = "secret"
+	p := payment.NewPaymentProvider(payment.NewCashSource(), payment.NewCardSource(cnf))
+	return NewPaymentJob(cnf, p, repo, fakeQueue[CaptureMessage]{}, fakeQueue[InstallmentMessage]{})
+}
+
+func cardOrder(t *testing.T, p *payment.Provider, id string, json string) payment.Order {
+	order, err := p.UnmarshalOrderJSON("card", id, []byte(json))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func TestPaymentJob_CancelOnBalanceFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		onFailure  booking.InstallmentFailureAction
+		deposit    payment.PaymentStatus
+		wantStatus payment.PaymentStatus
+		wantDone   bool
+	}{
+		{name: "refund paid deposit", onFailure: booking.RefundDepositFailureAction, deposit: payment.PaymentStatusSuccess, wantStatus: payment.PaymentStatusRefundPending},
+		{name: "void held deposit", onFailure: booking.RefundDepositFailureAction, deposit: payment.PaymentStatusAuthorized, wantStatus: payment.PaymentStatusCanceled, wantDone: true},
+		{name: "keep paid deposit", onFailure: booking.KeepDepositFailureAction, deposit: payment.PaymentStatusSuccess, wantStatus: payment.PaymentStatusSuccess, wantDone: true},
+		{name: "capture held deposit", onFailure: booking.KeepDepositFailureAction, deposit: payment.PaymentStatusAuthorized, wantStatus: payment.PaymentStatusCaptured, wantDone: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := newTestPaymentJob(&config.Config{}, &fakeRepo{})
+
+			r := &booking.Reservation{
+				ID:           "1",
+				PaymentType:  "card",
+				Cost:         money.New(100, "EUR"),
+				PaymentOrder: cardOrder(t, j.p, "1", `{"status":"`+string(tt.deposit)+`","amount":30,"currency":"EUR"}`),
+				Installments: []*booking.Installment{{
+					Amount:    money.New(70, "EUR"),
+					OnFailure: tt.onFailure,
+					Order:     cardOrder(t, j.p, booking.InstallmentOrderID("1", 0), `{"status":"failed","amount":70,"currency":"EUR"}`),
+				}},
+			}
+
+			done, err := j.Cancel(context.Background(), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (done != nil && *done) != tt.wantDone {
+				t.Errorf("Cancel() = %v, want done %v", done, tt.wantDone)
+			}
+			if r.PaymentOrder.Status() != tt.wantStatus {
+				t.Errorf("deposit order status = %s, want %s", r.PaymentOrder.Status(), tt.wantStatus)
+			}
+		})
+	}
+}
@@ -1,23 +1,33 @@
 package jobs
 
-import "github.com/antnmxmv/booking-service/internal/booking"
+import (
+	"context"
+	"errors"
 
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/money"
+)
+
+// PriceServiceFacade calculates cost in base currency of hotel
 type PriceServiceFacade interface {
-	GetPrice(reservationRequest booking.Reservation) (discounts []string, finalCost int, err error)
+	GetPrice(ctx context.Context, reservationRequest booking.Reservation) (discounts []string, finalCost money.Money, err error)
 }
 
 type PriceJob struct {
-	p  PriceServiceFacade
-	ch chan booking.JobResponse
+	p PriceServiceFacade
+	// rates convert cost to display currency of reservation
+	rates money.RateProvider
+	ch    chan booking.JobResponse
 }
 
-func NewPriceJob(p PriceServiceFacade) *PriceJob {
+func NewPriceJob(p PriceServiceFacade, rates money.RateProvider) *PriceJob {
 	// it will be synchronious
 	ch := make(chan booking.JobResponse)
 	close(ch)
 	return &PriceJob{
-		p:  p,
-		ch: ch,
+		p:     p,
+		rates: rates,
+		ch:    ch,
 	}
 }
 
@@ -25,18 +35,33 @@ func (p *PriceJob) Name() booking.ReservationStatus {
 	return "price_calculation"
 }
 
-func (p *PriceJob) Run(r *booking.Reservation) (*bool, error) {
-	discountIDs, cost, err := p.p.GetPrice(*r)
+func (p *PriceJob) Run(ctx context.Context, r *booking.Reservation) (*bool, error) {
+	discountIDs, cost, err := p.p.GetPrice(ctx, *r)
 	if err != nil {
-		return nil, err
+		// price service may answer on next attempt
+		return nil, booking.WrapError(booking.ErrTransient, err.Error())
 	}
 	r.AppliedDiscountIDs = discountIDs
 	r.Cost = cost
+
+	// cost is quoted in display currency once, so it does not change with rates later
+	r.DisplayCost = nil
+	if r.DisplayCurrency != "" {
+		displayCost, err := money.Convert(ctx, p.rates, cost, r.DisplayCurrency)
+		if err != nil && !errors.Is(err, money.ErrUnknownRate) {
+			// rates provider may answer on next attempt, unknown rate does not appear by itself
+			return nil, booking.WrapError(booking.ErrTransient, err.Error())
+		} else if err != nil {
+			return nil, err
+		}
+		r.DisplayCost = &displayCost
+	}
+
 	res := true
 	return &res, nil
 }
 
-func (p *PriceJob) Cancel(_ *booking.Reservation) (*bool, error) {
+func (p *PriceJob) Cancel(_ context.Context, _ *booking.Reservation) (*bool, error) {
 	// no need to cancel anything, but here could be request to
 	// discount service to reduce some user metrics
 	res := true
@@ -1,34 +1,66 @@
 package booking
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/antnmxmv/booking-service/internal/config"
 )
 
+// maxConflictRetries is how many times orchestrator tries to apply change to reservation
+// which is modified concurrently
+const maxConflictRetries = 3
+
 // DelayedQueue generalization for delayed message queue
 type DelayedQueue interface {
 	SendMessage(message string, delay time.Duration) error
 	Subscribe() <-chan string
+	// Ack tells queue that delivered message is processed.
+	// queue may deliver not acknowledged message again
+	Ack(message string) error
+	// Cancel removes message from queue
+	Cancel(message string) error
 }
 
 // ReservationOrchestrator does reservation lifecycle
 // it needed to implement 2PC with list of services that implements service interface
 type ReservationOrchestrator struct {
-	repo    Repository
-	jobs    []Job
+	repo Repository
+	// jobs are all known jobs, planResolver picks some of them for every reservation
+	jobs         []Job
+	planResolver PlanResolver
+	// deadlineQueue tracks deadlines of pending jobs
+	deadlineQueue DeadlineQueue
+	// timeout is deadline of pending job which doesn't declare its own
 	timeout time.Duration
-
-	doneCh chan struct{}
+	// retryPolicies are policies by job name, policy by empty name is for all other jobs
+	retryPolicies map[ReservationStatus]RetryPolicy
+	// failureActions are actions by job name, action by empty name is for all other jobs
+	failureActions map[ReservationStatus]FailureAction
+	// timeoutActions are actions by job name, action by empty name is for all other jobs
+	timeoutActions map[ReservationStatus]TimeoutAction
+	// onCompleted is called when reservation is finished or canceled
+	onCompleted func(reservationID string)
+	// onStatusChanged is called after reservation status or job data is saved
+	onStatusChanged func(r *Reservation)
+
+	// ctx is context of background work, e.g. handling of asynchronous job results.
+	// it is canceled on stop
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // service represents some consistent operator
-// it must not return error if request is duplicated, but must provide current request status
+// it must not return error if request is duplicated, but must provide current request status.
+// context of Run and Cancel is canceled with request which started the job
 
 type Job interface {
 	Name() ReservationStatus
-	Run(*Reservation) (*bool, error)
-	Cancel(*Reservation) (*bool, error)
+	Run(context.Context, *Reservation) (*bool, error)
+	Cancel(context.Context, *Reservation) (*bool, error)
 	Subscribe() (<-chan JobResponse, error)
 }
 
@@ -37,28 +69,48 @@ type JobResponse struct {
 	IsSucceeded   bool
 	UpdateData    func(r *Reservation)
 	JobName       ReservationStatus
+	// CancelReservation asks to compensate finished reservation which job can not keep anymore,
+	// e.g. later installment of payment failed. IsSucceeded is not used then
+	CancelReservation bool
 }
 
+// NewReservationOrchestrator creates orchestrator. all jobs are run in given order if plan resolver is nil
 func NewReservationOrchestrator(
 	r Repository,
+	deadlineQueue DeadlineQueue,
+	planResolver PlanResolver,
 	jobs ...Job,
 ) *ReservationOrchestrator {
+	if planResolver == nil {
+		planResolver = allJobsPlan{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &ReservationOrchestrator{
-		repo:   r,
-		jobs:   jobs,
-		doneCh: make(chan struct{}),
+		planResolver:    planResolver,
+		repo:            r,
+		jobs:            jobs,
+		deadlineQueue:   deadlineQueue,
+		onCompleted:     func(string) {},
+		onStatusChanged: func(*Reservation) {},
+		ctx:             ctx,
+		cancel:          cancel,
 	}
 }
 
-// execute runs all jobs from last reservation status
-func (s *ReservationOrchestrator) execute(reservation *Reservation, skipCurrentJob bool) error {
+// execute runs all steps of plan from last reservation status
+func (s *ReservationOrchestrator) execute(ctx context.Context, reservation *Reservation, skipCurrentJob bool) error {
+
+	steps, err := s.planSteps(ctx, reservation)
+	if err != nil {
+		return err
+	}
 
 	found := false
 	if reservation.Status == CreatedReservationStatus {
 		found = true
 	}
-	for _, job := range s.jobs {
-		if !found && job.Name() == reservation.Status {
+	for _, step := range steps {
+		if !found && step.name == reservation.Status {
 			found = true
 			if skipCurrentJob {
 				continue
@@ -66,39 +118,75 @@ func (s *ReservationOrchestrator) execute(reservation *Reservation, skipCurrentJ
 		} else if !found {
 			continue
 		}
-		reservation.Status = ReservationStatus(job.Name())
-
-		isSucceeded, err := job.Run(reservation)
+		reservation.Status = step.name
+		if reservation.StepAttempts == nil {
+			reservation.StepAttempts = map[ReservationStatus]int{}
+		}
+		reservation.StepAttempts[reservation.Status]++
 
-		if err := s.repo.UpdateReservation(reservation); err != nil {
+		// command is saved before jobs are run, so run is not lost if service stops meanwhile
+		command := newRunCommand(reservation)
+		if err := s.repo.UpdateReservationWithOutbox(ctx, reservation, []*OutboxMessage{command}, nil); err != nil {
 			return err
 		}
 
-		if err != nil {
+		if done, err := s.runCommand(ctx, reservation, step, command); !done {
 			return err
 		}
+	}
 
-		if isSucceeded == nil {
-			return nil
-		}
+	reservation.Status = FinishedReservationStatus
 
-		if !*isSucceeded {
-			return fmt.Errorf("transaction failed on %s step", job.Name())
-		}
+	if err := s.repo.UpdateReservation(ctx, reservation); err != nil {
+		return err
 	}
+	s.onStatusChanged(reservation)
 
-	reservation.Status = FinishedReservationStatus
+	s.onCompleted(reservation.ID)
 
 	return nil
 }
 
-func (s *ReservationOrchestrator) rollback(reservation *Reservation, skipCurrentJob bool) error {
+func (s *ReservationOrchestrator) retryPolicy(jobName ReservationStatus) RetryPolicy {
+	if policy, ok := s.retryPolicies[jobName]; ok {
+		return policy
+	}
+	return s.retryPolicies[""]
+}
+
+// scheduleRetry runs current job of reservation again after backoff
+func (s *ReservationOrchestrator) scheduleRetry(reservation *Reservation) {
+	id, step, attempt := reservation.ID, reservation.Status, reservation.StepAttempts[reservation.Status]
+
+	time.AfterFunc(s.retryPolicy(step).backoff(attempt), func() {
+		// retry outlives request which started the job
+		ctx := s.ctx
+		if ctx.Err() != nil {
+			return
+		}
+
+		_ = s.retryOnConflict(ctx, id, func(r *Reservation) error {
+			// reservation could be canceled or its job could be run by someone else meanwhile
+			if r.Status != step || r.StepAttempts[step] != attempt {
+				return nil
+			}
+			return s.execute(ctx, r, false)
+		})
+	})
+}
+
+func (s *ReservationOrchestrator) rollback(ctx context.Context, reservation *Reservation, skipCurrentJob bool) error {
+	steps, err := s.planSteps(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
 	found := false
 	if reservation.Status == FinishedReservationStatus {
 		found = true
 	}
-	for i := len(s.jobs) - 1; i >= 0; i-- {
-		if !found && s.jobs[i].Name() == reservation.Status {
+	for i := len(steps) - 1; i >= 0; i-- {
+		if !found && steps[i].name == reservation.Status {
 			found = true
 			if skipCurrentJob {
 				continue
@@ -106,8 +194,8 @@ func (s *ReservationOrchestrator) rollback(reservation *Reservation, skipCurrent
 		} else if !found {
 			continue
 		}
-		if _, err := s.jobs[i].Cancel(reservation); err != nil {
-			reservation.Status = s.jobs[i].Name()
+		if err := s.cancelStep(ctx, reservation, steps[i]); err != nil {
+			reservation.Status = steps[i].name
 
 			return err
 		}
@@ -116,13 +204,50 @@ func (s *ReservationOrchestrator) rollback(reservation *Reservation, skipCurrent
 	return nil
 }
 
-func (s *ReservationOrchestrator) consumePersistedData() error {
-	notFinishedReservations, err := s.repo.GetNotFinishedReservations()
+// cancelStep compensates job of step. only started jobs of group are compensated,
+// failed or not run jobs have nothing to cancel
+func (s *ReservationOrchestrator) cancelStep(ctx context.Context, reservation *Reservation, step plannedStep) error {
+	for i := len(step.jobs) - 1; i >= 0; i-- {
+		job := step.jobs[i]
+		if step.isGroup() && !reservation.Steps[job.Name()].isStarted() {
+			continue
+		}
+		isCanceled, err := job.Cancel(ctx, reservation)
+		s.logStep(ctx, reservation, job.Name(), CancelSagaAction, isCanceled, err)
+		if err != nil {
+			return err
+		}
+		if isCanceled != nil && *isCanceled {
+			reservation.setStepStatus(job.Name(), CanceledStepStatus)
+		}
+	}
+	return nil
+}
+
+// consumePersistedData goes on with reservations which were in progress when service stopped.
+// steps which results were not saved are relayed from outbox
+func (s *ReservationOrchestrator) consumePersistedData(ctx context.Context) error {
+	commands, err := s.repo.GetOutboxMessages(ctx)
+	if err != nil {
+		return err
+	}
+
+	relayed := make(map[string]bool, len(commands))
+	for _, command := range commands {
+		relayed[command.ReservationID] = true
+		_ = s.relay(ctx, command)
+	}
+
+	notFinishedReservations, err := s.repo.GetNotFinishedReservations(ctx)
 	if err != nil {
 		return err
 	}
 	for _, r := range notFinishedReservations {
-		s.execute(r, false)
+		// failed reservation waits for user
+		if r.Failure != nil || relayed[r.ID] {
+			continue
+		}
+		_ = s.resume(ctx, r)
 	}
 
 	return nil
@@ -155,24 +280,13 @@ func (s *ReservationOrchestrator) consumeIncomingChanges() error {
 	}()
 
 	go func() {
+		ctx := s.ctx
 		for {
 			select {
 			case update := <-updatesCh:
-				r, err := s.repo.GetReservationByID(update.ReservationID)
-				if err != nil {
-					continue
-				}
-				if r.Status == update.JobName {
-					update.UpdateData(r)
-					if err := s.repo.UpdateReservation(r); err != nil {
-						// nack or requeue
-					}
-					if update.IsSucceeded {
-						_ = s.execute(r, true)
-					}
-				}
-
-			case <-s.doneCh:
+				_ = s.handleJobResponse(ctx, update)
+
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -181,10 +295,97 @@ func (s *ReservationOrchestrator) consumeIncomingChanges() error {
 	return nil
 }
 
-func (s *ReservationOrchestrator) run(timeout time.Duration) error {
-	s.timeout = timeout
+// handleJobResponse saves asynchronous result of job and goes on with saga when step is done
+func (s *ReservationOrchestrator) handleJobResponse(ctx context.Context, update JobResponse) error {
+	if update.CancelReservation {
+		return s.handleCancelRequest(ctx, update)
+	}
+
+	var (
+		r          *Reservation
+		step       plannedStep
+		stepResult *bool
+	)
+
+	err := s.retryOnConflict(ctx, update.ReservationID, func(stored *Reservation) error {
+		r = nil
+
+		current, ok := s.currentStep(ctx, stored)
+		if !ok || current.job(update.JobName) == nil {
+			return nil
+		}
+		// job answers only once, other jobs of group may still be pending
+		if status, ok := stored.Steps[update.JobName]; ok && status != PendingStepStatus {
+			return nil
+		}
+
+		isSucceeded := update.IsSucceeded
+		update.UpdateData(stored)
+		stored.setStepStatus(update.JobName, stepStatus(&isSucceeded, nil))
 
-	if err := s.consumePersistedData(); err != nil {
+		stepResult = s.joinStep(stored, current)
+		if stepResult != nil {
+			_ = s.deadlineQueue.Cancel(s.stepDeadline(stored))
+		}
+		if err := s.repo.UpdateReservation(ctx, stored); err != nil {
+			return err
+		}
+		s.logStep(ctx, stored, update.JobName, ResultSagaAction, &isSucceeded, nil)
+
+		r, step = stored, current
+		return nil
+	})
+	if err != nil || r == nil {
+		return err
+	}
+	s.onStatusChanged(r)
+
+	if stepResult == nil {
+		return nil
+	}
+	if *stepResult {
+		return s.execute(ctx, r, true)
+	}
+	return s.fail(ctx, r, step.name, fmt.Errorf("transaction failed on %s step", update.JobName))
+}
+
+// handleCancelRequest compensates finished reservation by request of job
+func (s *ReservationOrchestrator) handleCancelRequest(ctx context.Context, update JobResponse) error {
+	return s.retryOnConflict(ctx, update.ReservationID, func(r *Reservation) error {
+		if r.Status != FinishedReservationStatus {
+			return nil
+		}
+
+		update.UpdateData(r)
+		isSucceeded := false
+		s.logStep(ctx, r, update.JobName, ResultSagaAction, &isSucceeded, nil)
+
+		return s.compensate(ctx, r)
+	})
+}
+
+// retryOnConflict reads reservation and runs fn over it. fn is run again over fresh copy of reservation
+// while it fails with ErrConcurrentModification, so fn must check again if its change is still needed
+func (s *ReservationOrchestrator) retryOnConflict(ctx context.Context, reservationID string, fn func(r *Reservation) error) error {
+	for attempt := 1; ; attempt++ {
+		r, err := s.repo.GetReservationByID(ctx, reservationID)
+		if err != nil {
+			return err
+		}
+		err = fn(r)
+		if !errors.Is(err, ErrConcurrentModification) || attempt >= maxConflictRetries {
+			return err
+		}
+	}
+}
+
+func (s *ReservationOrchestrator) run(cnf config.Booking) error {
+	s.timeout = cnf.IdleReservationTimeout
+	s.retryPolicies = retryPoliciesFromConfig(cnf.Retry)
+	s.failureActions = failureActionsFromConfig(cnf.Failure)
+	s.timeoutActions = timeoutActionsFromConfig(cnf.Timeout)
+
+	if err := s.consumePersistedData(s.ctx); err != nil {
 		return err
 	}
 
@@ -192,9 +393,12 @@ func (s *ReservationOrchestrator) run(timeout time.Duration) error {
 		return err
 	}
 
+	s.consumeDeadlines()
+	s.relayPeriodically(cnf.OutboxRelayInterval)
+
 	return nil
 }
 
 func (s *ReservationOrchestrator) stop() {
-	close(s.doneCh)
+	s.cancel()
 }
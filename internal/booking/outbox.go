@@ -0,0 +1,160 @@
+package booking
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// OutboxMessage is command to run step of reservation. it is saved together with reservation
+// before jobs of step are run and it is removed together with their results, so step is run
+// again after restart only if its results are not saved
+type OutboxMessage struct {
+	ID            string            `json:"id"`
+	ReservationID string            `json:"reservation_id"`
+	Step          ReservationStatus `json:"step"`
+	Attempt       int               `json:"attempt"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+// newRunCommand makes command to run current step of reservation.
+// version is a part of id, so every run of step has its own id
+func newRunCommand(r *Reservation) *OutboxMessage {
+	return &OutboxMessage{
+		ID:            fmt.Sprintf("%s/%s/%d", r.ID, r.Status, r.Version),
+		ReservationID: r.ID,
+		Step:          r.Status,
+		Attempt:       r.StepAttempts[r.Status],
+		CreatedAt:     time.Now(),
+	}
+}
+
+type commandIDKey struct{}
+
+func withCommandID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, commandIDKey{}, id)
+}
+
+// CommandID returns id of outbox command which job is run by. the same command is relayed
+// after restart, so job can use it as idempotency key of its side effects
+func CommandID(ctx context.Context) string {
+	id, _ := ctx.Value(commandIDKey{}).(string)
+	return id
+}
+
+// runCommand runs jobs of step by outbox command and saves their results removing command from outbox.
+// it returns true if saga can go on to the next step
+func (s *ReservationOrchestrator) runCommand(ctx context.Context, r *Reservation, step plannedStep, command *OutboxMessage) (bool, error) {
+	isSucceeded, err := s.runStep(withCommandID(ctx, command.ID), r, step)
+
+	if err := s.repo.UpdateReservationWithOutbox(ctx, r, nil, []string{command.ID}); err != nil {
+		return false, err
+	}
+	s.onStatusChanged(r)
+
+	if err != nil {
+		if s.retryPolicy(step.name).shouldRetry(r.StepAttempts[step.name], err) {
+			// reservation stays in progress until next attempt
+			s.scheduleRetry(r)
+			return false, nil
+		}
+		return false, s.fail(ctx, r, step.name, err)
+	}
+
+	if isSucceeded == nil {
+		return false, s.waitForResult(r, step)
+	}
+
+	if !*isSucceeded {
+		return false, s.fail(ctx, r, step.name, fmt.Errorf("transaction failed on %s step", step.name))
+	}
+
+	return true, nil
+}
+
+// relay runs step by command which was saved, but its results were not, e.g. service crashed meanwhile
+func (s *ReservationOrchestrator) relay(ctx context.Context, command *OutboxMessage) error {
+	r, err := s.repo.GetReservationByID(ctx, command.ReservationID)
+	if err != nil {
+		return err
+	}
+
+	step, ok := s.currentStep(ctx, r)
+	// reservation was changed by someone else after command was saved, so command is not actual
+	if !ok || r.Status != command.Step || r.StepAttempts[command.Step] != command.Attempt || r.Failure != nil {
+		return s.repo.UpdateReservationWithOutbox(ctx, r, nil, []string{command.ID})
+	}
+
+	done, err := s.runCommand(ctx, r, step, command)
+	if !done {
+		return err
+	}
+
+	return s.execute(ctx, r, true)
+}
+
+// resume goes on with reservation which has no command in outbox
+func (s *ReservationOrchestrator) resume(ctx context.Context, r *Reservation) error {
+	if r.Status == CreatedReservationStatus {
+		return s.execute(ctx, r, false)
+	}
+
+	step, ok := s.currentStep(ctx, r)
+	if !ok {
+		return nil
+	}
+
+	result := s.joinStep(r, step)
+	switch {
+	case result == nil:
+		// pending job is waiting for its result or deadline
+		return nil
+	case *result:
+		return s.execute(ctx, r, true)
+	default:
+		// failed run was waiting for retry
+		return s.execute(ctx, r, false)
+	}
+}
+
+// relayPeriodically relays commands which are left in outbox while service is running,
+// e.g. when request which ran the step was canceled before results were saved
+func (s *ReservationOrchestrator) relayPeriodically(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				s.relayStale(s.ctx, time.Now().Add(-interval))
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// relayStale relays commands created before given time, younger ones may be still running.
+// if command is still running anyway, versioning lets only one of runs save results
+func (s *ReservationOrchestrator) relayStale(ctx context.Context, createdBefore time.Time) {
+	commands, err := s.repo.GetOutboxMessages(ctx)
+	if err != nil {
+		log.Printf("[orchestrator] reading outbox: %s", err.Error())
+		return
+	}
+
+	for _, command := range commands {
+		if !command.CreatedAt.Before(createdBefore) {
+			continue
+		}
+		if err := s.relay(ctx, command); err != nil {
+			log.Printf("[orchestrator] relaying command %s: %s", command.ID, err.Error())
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package booking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+)
+
+// groupSeparator joins names of jobs of group into step name
+const groupSeparator = "+"
+
+// PlanStep is job or group of jobs which are run concurrently. saga goes to next step
+// only when all jobs of group are succeeded. jobs of group get copies of reservation,
+// so they must not change it, asynchronous results still update it by JobResponse
+type PlanStep []ReservationStatus
+
+// Name is name of single job or names of group jobs joined by "+".
+// reservation has this status while step is running
+func (p PlanStep) Name() ReservationStatus {
+	names := make([]string, len(p))
+	for i, name := range p {
+		names[i] = string(name)
+	}
+	return ReservationStatus(strings.Join(names, groupSeparator))
+}
+
+// MarshalJSON writes single job step as string and group as list
+func (p PlanStep) MarshalJSON() ([]byte, error) {
+	if len(p) == 1 {
+		return json.Marshal(p[0])
+	}
+	return json.Marshal([]ReservationStatus(p))
+}
+
+func (p *PlanStep) UnmarshalJSON(data []byte) error {
+	var name ReservationStatus
+	if err := json.Unmarshal(data, &name); err == nil {
+		*p = PlanStep{name}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]ReservationStatus)(p))
+}
+
+// PlanResolver picks steps which are run over reservation and their order.
+// nil plan means all jobs of orchestrator one by one
+type PlanResolver interface {
+	ResolvePlan(ctx context.Context, r *Reservation) ([]PlanStep, error)
+}
+
+// ConfigPlanResolver takes plans from booking.plans of config
+type ConfigPlanResolver struct {
+	cnf *config.Config
+}
+
+func NewConfigPlanResolver(cnf *config.Config) *ConfigPlanResolver {
+	return &ConfigPlanResolver{cnf: cnf}
+}
+
+func (p *ConfigPlanResolver) ResolvePlan(ctx context.Context, r *Reservation) ([]PlanStep, error) {
+	plans := p.cnf.Booking.Plans
+
+	steps := plans.Default
+	if name, ok := plans.Users[r.UserID]; ok {
+		if steps, ok = plans.Named[name]; !ok {
+			return nil, fmt.Errorf("plan %s of user %s is not configured", name, r.UserID)
+		}
+		// empty named plan is valid, reservation is finished right away
+		if steps == nil {
+			steps = []config.PlanStep{}
+		}
+	}
+
+	if steps == nil {
+		return nil, nil
+	}
+
+	res := make([]PlanStep, len(steps))
+	for i, step := range steps {
+		res[i] = make(PlanStep, len(step))
+		for j, name := range step {
+			res[i][j] = ReservationStatus(name)
+		}
+	}
+	return res, nil
+}
+
+// plannedStep is step of reservation plan with its jobs
+type plannedStep struct {
+	name ReservationStatus
+	jobs []Job
+}
+
+func (p plannedStep) isGroup() bool {
+	return len(p.jobs) > 1
+}
+
+func (p plannedStep) job(name ReservationStatus) Job {
+	for _, job := range p.jobs {
+		if job.Name() == name {
+			return job
+		}
+	}
+	return nil
+}
+
+// planSteps returns steps of reservation plan. plan is resolved only once and saved
+// with reservation, so reservation is recovered with the same jobs after restart
+func (s *ReservationOrchestrator) planSteps(ctx context.Context, r *Reservation) ([]plannedStep, error) {
+	if r.Plan == nil {
+		plan, err := s.planResolver.ResolvePlan(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		if plan == nil {
+			plan = make([]PlanStep, len(s.jobs))
+			for i, job := range s.jobs {
+				plan[i] = PlanStep{job.Name()}
+			}
+		}
+		r.Plan = plan
+	}
+
+	res := make([]plannedStep, len(r.Plan))
+	for i, step := range r.Plan {
+		if len(step) == 0 {
+			return nil, fmt.Errorf("plan of reservation %s has empty step", r.ID)
+		}
+		res[i] = plannedStep{name: step.Name(), jobs: make([]Job, len(step))}
+		for j, name := range step {
+			if res[i].jobs[j] = s.job(name); res[i].jobs[j] == nil {
+				return nil, fmt.Errorf("plan of reservation %s has unknown job %s", r.ID, name)
+			}
+		}
+	}
+	return res, nil
+}
+
+// currentStep returns step of plan which reservation is on
+func (s *ReservationOrchestrator) currentStep(ctx context.Context, r *Reservation) (plannedStep, bool) {
+	steps, err := s.planSteps(ctx, r)
+	if err != nil {
+		return plannedStep{}, false
+	}
+	for _, step := range steps {
+		if step.name == r.Status {
+			return step, true
+		}
+	}
+	return plannedStep{}, false
+}
+
+func (s *ReservationOrchestrator) job(name ReservationStatus) Job {
+	for _, job := range s.jobs {
+		if job.Name() == name {
+			return job
+		}
+	}
+	return nil
+}
+
+// allJobsPlan is resolver of orchestrator without plans
+type allJobsPlan struct{}
+
+func (allJobsPlan) ResolvePlan(_ context.Context, _ *Reservation) ([]PlanStep, error) {
+	return nil, nil
+}
@@ -1,6 +1,7 @@
 package booking
 
 import (
+	"context"
 	"errors"
 	"time"
 )
@@ -10,20 +11,44 @@ var (
 	ErrDuplicate            = errors.New("reservation with this id already exists")
 	ErrNotWorkingDays       = errors.New("some dates in request are not listed as available to book")
 	ErrNotListedPaymentType = errors.New("payment type is not allowed by hotel")
+	ErrNotFound             = errors.New("reservation not found")
+	ErrNotOwner             = errors.New("reservation belongs to another user")
+	ErrAlreadyCanceled      = errors.New("reservation is already canceled")
+	ErrAlreadyFinished      = errors.New("reservation is already finished")
+	// ErrConcurrentModification is returned when reservation was changed by someone else since it was read
+	ErrConcurrentModification = errors.New("reservation is modified concurrently")
 )
 
+// Repository stores reservations. canceled context aborts operation, changes are not saved then
 type Repository interface {
-	CreateReservation(reservation *ReservationRequest) (*Reservation, error)
+	CreateReservation(ctx context.Context, reservation *ReservationRequest) (*Reservation, error)
 
-	CancelReservation(id string) error
+	// CancelReservation marks reservation canceled and releases its quota whatever version it has.
+	// version is incremented, so copies read before can not overwrite it
+	CancelReservation(ctx context.Context, id string) error
 
-	UpdateReservation(reservation *Reservation) error
+	// UpdateReservation saves reservation if stored one has the same version, otherwise it returns
+	// ErrConcurrentModification. version of reservation is incremented on success
+	UpdateReservation(ctx context.Context, reservation *Reservation) error
 
-	GetRoomsByDates(hotelID string, startDate, endDate time.Time) ([]*RoomAvailability, error)
+	// UpdateReservationWithOutbox saves reservation like UpdateReservation and in the same transaction
+	// adds messages to outbox and removes messages by id
+	UpdateReservationWithOutbox(ctx context.Context, reservation *Reservation, add []*OutboxMessage, remove []string) error
 
-	GetNotFinishedReservations() ([]*Reservation, error)
+	// GetOutboxMessages returns all messages of outbox in order of adding
+	GetOutboxMessages(ctx context.Context) ([]*OutboxMessage, error)
 
-	GetReservationByID(id string) (*Reservation, error)
+	GetRoomsByDates(ctx context.Context, hotelID string, startDate, endDate time.Time) ([]*RoomAvailability, error)
 
-	GetReservationsByUserID(userID string) ([]*Reservation, error)
+	GetNotFinishedReservations(ctx context.Context) ([]*Reservation, error)
+
+	GetReservationByID(ctx context.Context, id string) (*Reservation, error)
+
+	GetReservationsByUserID(ctx context.Context, userID string) ([]*Reservation, error)
+
+	// AppendSagaLog adds entry to the end of reservation's saga log
+	AppendSagaLog(ctx context.Context, entry *SagaLogEntry) error
+
+	// GetSagaLog returns saga log of reservation in order of appending
+	GetSagaLog(ctx context.Context, reservationID string) ([]*SagaLogEntry, error)
 }
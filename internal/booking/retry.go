@@ -0,0 +1,86 @@
+package booking
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+)
+
+// ErrTransient marks job errors which may disappear on next attempt, e.g. dependency is unavailable.
+// jobs wrap it with WrapError
+var ErrTransient = errors.New("transient error")
+
+const (
+	// RetryOnTransient retries only errors wrapping ErrTransient
+	RetryOnTransient = "transient"
+	// RetryOnAny retries every job error
+	RetryOnAny = "any"
+)
+
+// RetryPolicy tells orchestrator how to run job again after error
+type RetryPolicy struct {
+	// MaxAttempts is count of runs including the first one, job is not retried if it is less than 2
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is part of backoff which is randomized, from 0 to 1
+	Jitter    float64
+	Retryable func(err error) bool
+}
+
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+func retryPolicyFromConfig(cnf config.RetryPolicy) RetryPolicy {
+	p := RetryPolicy{
+		MaxAttempts:    cnf.MaxAttempts,
+		InitialBackoff: cnf.InitialBackoff,
+		MaxBackoff:     cnf.MaxBackoff,
+		Multiplier:     cnf.Multiplier,
+		Jitter:         cnf.Jitter,
+		Retryable:      IsTransient,
+	}
+	if cnf.RetryOn == RetryOnAny {
+		p.Retryable = func(err error) bool { return err != nil }
+	}
+	return p
+}
+
+// retryPoliciesFromConfig returns policy for every job which has its own settings
+// and policy for all others by empty name
+func retryPoliciesFromConfig(cnf config.Retry) map[ReservationStatus]RetryPolicy {
+	res := map[ReservationStatus]RetryPolicy{"": retryPolicyFromConfig(cnf.Default)}
+	for name, policy := range cnf.Jobs {
+		res[ReservationStatus(name)] = retryPolicyFromConfig(policy)
+	}
+	return res
+}
+
+// shouldRetry reports if job which failed with err on attempt (starting from 1) can be run again
+func (p RetryPolicy) shouldRetry(attempt int, err error) bool {
+	return attempt < p.MaxAttempts && p.Retryable != nil && p.Retryable(err)
+}
+
+// backoff returns delay before next attempt, it grows exponentially after each attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+		if p.MaxBackoff > 0 && delay >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+
+	// spread retries of reservations which failed at the same time
+	jitter := delay * p.Jitter
+	delay = delay - jitter + rand.Float64()*2*jitter
+
+	return time.Duration(delay)
+}
@@ -0,0 +1,65 @@
+package booking
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type SagaAction string
+
+const (
+	RunSagaAction    SagaAction = "run"
+	CancelSagaAction SagaAction = "cancel"
+	// ResultSagaAction is asynchronous result of pending run
+	ResultSagaAction SagaAction = "result"
+)
+
+type SagaOutcome string
+
+const (
+	SucceededSagaOutcome SagaOutcome = "succeeded"
+	FailedSagaOutcome    SagaOutcome = "failed"
+	PendingSagaOutcome   SagaOutcome = "pending"
+	ErrorSagaOutcome     SagaOutcome = "error"
+)
+
+// SagaLogEntry is record of append-only log of job actions over reservation
+type SagaLogEntry struct {
+	ReservationID string            `json:"reservation_id"`
+	Step          ReservationStatus `json:"step"`
+	Action        SagaAction        `json:"action"`
+	Outcome       SagaOutcome       `json:"outcome"`
+	Error         string            `json:"error,omitempty"`
+	Time          time.Time         `json:"time"`
+}
+
+func newSagaLogEntry(r *Reservation, step ReservationStatus, action SagaAction, isSucceeded *bool, err error) *SagaLogEntry {
+	entry := &SagaLogEntry{
+		ReservationID: r.ID,
+		Step:          step,
+		Action:        action,
+		Time:          time.Now(),
+	}
+	switch {
+	case err != nil:
+		entry.Outcome = ErrorSagaOutcome
+		entry.Error = err.Error()
+	case isSucceeded == nil:
+		entry.Outcome = PendingSagaOutcome
+	case *isSucceeded:
+		entry.Outcome = SucceededSagaOutcome
+	default:
+		entry.Outcome = FailedSagaOutcome
+	}
+	return entry
+}
+
+// logStep appends job action to saga log. log is for audit only,
+// so reservation processing goes on even if it is not written
+func (s *ReservationOrchestrator) logStep(ctx context.Context, r *Reservation, step ReservationStatus, action SagaAction, isSucceeded *bool, err error) {
+	entry := newSagaLogEntry(r, step, action, isSucceeded, err)
+	if err := s.repo.AppendSagaLog(ctx, entry); err != nil {
+		log.Printf("[orchestrator] writing saga log of reservation %s: %s", r.ID, err.Error())
+	}
+}
@@ -0,0 +1,156 @@
+package booking
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// StepStatus is state of single job of reservation plan
+type StepStatus string
+
+const (
+	PendingStepStatus   StepStatus = "pending"
+	SucceededStepStatus StepStatus = "succeeded"
+	FailedStepStatus    StepStatus = "failed"
+	CanceledStepStatus  StepStatus = "canceled"
+)
+
+func stepStatus(isSucceeded *bool, err error) StepStatus {
+	switch {
+	case err != nil:
+		return FailedStepStatus
+	case isSucceeded == nil:
+		return PendingStepStatus
+	case *isSucceeded:
+		return SucceededStepStatus
+	default:
+		return FailedStepStatus
+	}
+}
+
+// isStarted tells if job has done something which needs compensation
+func (s StepStatus) isStarted() bool {
+	return s == PendingStepStatus || s == SucceededStepStatus
+}
+
+func (r *Reservation) setStepStatus(jobName ReservationStatus, status StepStatus) {
+	if r.Steps == nil {
+		r.Steps = map[ReservationStatus]StepStatus{}
+	}
+	r.Steps[jobName] = status
+}
+
+// runStep runs job of step. jobs of group which are not succeeded yet are run
+// concurrently over copies of reservation and their results are joined
+func (s *ReservationOrchestrator) runStep(ctx context.Context, r *Reservation, step plannedStep) (*bool, error) {
+	if !step.isGroup() {
+		job := step.jobs[0]
+		isSucceeded, err := job.Run(ctx, r)
+		s.logStep(ctx, r, job.Name(), RunSagaAction, isSucceeded, err)
+		r.setStepStatus(job.Name(), stepStatus(isSucceeded, err))
+		return isSucceeded, err
+	}
+
+	type result struct {
+		member      *Reservation
+		isSucceeded *bool
+		err         error
+	}
+
+	results := make([]*result, len(step.jobs))
+	wg := sync.WaitGroup{}
+
+	for i, job := range step.jobs {
+		if r.Steps[job.Name()] == SucceededStepStatus {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, job Job, member *Reservation) {
+			defer wg.Done()
+			isSucceeded, err := job.Run(ctx, member)
+			results[i] = &result{member: member, isSucceeded: isSucceeded, err: err}
+		}(i, job, r.Copy())
+	}
+
+	wg.Wait()
+
+	base := r.Copy()
+	for _, res := range results {
+		if res != nil {
+			r.mergeJobOutput(base, res.member)
+		}
+	}
+
+	var (
+		firstErr error
+		declined bool
+	)
+	for i, job := range step.jobs {
+		if results[i] == nil {
+			continue
+		}
+		s.logStep(ctx, r, job.Name(), RunSagaAction, results[i].isSucceeded, results[i].err)
+		r.setStepStatus(job.Name(), stepStatus(results[i].isSucceeded, results[i].err))
+
+		if results[i].err != nil {
+			if firstErr == nil {
+				firstErr = results[i].err
+			}
+		} else if results[i].isSucceeded != nil && !*results[i].isSucceeded {
+			declined = true
+		}
+	}
+
+	// declined job makes group failed, so there is no reason to retry jobs with errors
+	if declined {
+		res := false
+		return &res, nil
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return s.joinStep(r, step), nil
+}
+
+// joinStep is result of step by statuses of its jobs. step is failed when any job is failed,
+// it is pending while any job is pending and succeeded when all jobs are succeeded
+func (s *ReservationOrchestrator) joinStep(r *Reservation, step plannedStep) *bool {
+	res := true
+	pending := false
+	for _, job := range step.jobs {
+		switch r.Steps[job.Name()] {
+		case SucceededStepStatus:
+		case PendingStepStatus:
+			pending = true
+		default:
+			res = false
+			return &res
+		}
+	}
+	if pending {
+		return nil
+	}
+	return &res
+}
+
+// mergeJobOutput takes fields changed by job of group on its copy of reservation.
+// jobs of group may set cost, discounts, payment order and installments, other changes are dropped.
+// if several jobs change the same field, change of the last job in group wins
+func (r *Reservation) mergeJobOutput(base, member *Reservation) {
+	if member.Cost != base.Cost {
+		r.Cost = member.Cost
+	}
+	if !reflect.DeepEqual(member.DisplayCost, base.DisplayCost) {
+		r.DisplayCost = member.DisplayCost
+	}
+	if !reflect.DeepEqual(member.AppliedDiscountIDs, base.AppliedDiscountIDs) {
+		r.AppliedDiscountIDs = member.AppliedDiscountIDs
+	}
+	if !reflect.DeepEqual(member.PaymentOrder, base.PaymentOrder) {
+		r.PaymentOrder = member.PaymentOrder
+	}
+	if !reflect.DeepEqual(member.Installments, base.Installments) {
+		r.Installments = member.Installments
+	}
+}
@@ -0,0 +1,40 @@
+package booking
+
+import (
+	"testing"
+
+	"github.com/antnmxmv/booking-service/internal/money"
+)
+
+func TestReservation_MergeJobOutput(t *testing.T) {
+	r := &Reservation{
+		ID:           "1",
+		Cost:         money.New(100, "EUR"),
+		Steps:        map[ReservationStatus]StepStatus{"price": SucceededStepStatus},
+		Installments: []*Installment{{Amount: money.New(50, "EUR")}},
+		Failure:      &ReservationFailure{Step: "price", Reason: "unavailable"},
+	}
+	base := r.Copy()
+
+	// jobs of group change their copies concurrently
+	price, loyalty := r.Copy(), r.Copy()
+	price.Cost = money.New(200, "EUR")
+	price.Steps["price"] = FailedStepStatus
+	loyalty.AppliedDiscountIDs = append(loyalty.AppliedDiscountIDs, "loyalty")
+	loyalty.Installments[0].Amount = money.New(10, "EUR")
+	price.Failure.Reason = "changed"
+
+	if r.Steps["price"] != SucceededStepStatus || r.Installments[0].Amount != money.New(50, "EUR") || r.Failure.Reason != "unavailable" {
+		t.Fatalf("change of copy is visible in reservation: steps %v, installment %s, failure %+v", r.Steps, r.Installments[0].Amount, r.Failure)
+	}
+
+	r.mergeJobOutput(base, price)
+	r.mergeJobOutput(base, loyalty)
+
+	if r.Cost != money.New(200, "EUR") || len(r.AppliedDiscountIDs) != 1 || r.Installments[0].Amount != money.New(10, "EUR") {
+		t.Errorf("merged reservation = cost %s, discounts %v, installment %s", r.Cost, r.AppliedDiscountIDs, r.Installments[0].Amount)
+	}
+	if r.Steps["price"] != SucceededStepStatus {
+		t.Errorf("step status %s is merged, it is set by orchestrator only", r.Steps["price"])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/antnmxmv/booking-service/internal/money"
 	"github.com/antnmxmv/booking-service/internal/payment"
 )
 
@@ -25,10 +26,69 @@ type Reservation struct {
 	PaymentOrder          payment.Order        `json:"payment_order,omitempty"`
 	StartDate             time.Time            `json:"start_date"`
 	EndDate               time.Time            `json:"end_date"`
-	Cost                  int                  `json:"cost"`
+	Cost                  money.Money          `json:"cost"`
 	Status                ReservationStatus    `json:"status"`
 	AppliedDiscountIDs    []string             `json:"applied_discount_ids"`
 	LastUpdateTime        time.Time            `json:"last_update"`
+	// Plan is steps run over reservation in order, it is resolved on first run
+	Plan []PlanStep `json:"plan"`
+	// Steps are statuses of every job of plan, Status is current step only
+	Steps map[ReservationStatus]StepStatus `json:"steps,omitempty"`
+	// StepAttempts is count of runs of every job in current saga execution
+	StepAttempts map[ReservationStatus]int `json:"step_attempts,omitempty"`
+	// Failure is set when step failed and is not retried anymore
+	Failure *ReservationFailure `json:"failure,omitempty"`
+	// Installments are parts of cost charged after saga, PaymentOrder pays the rest
+	Installments []*Installment `json:"installments,omitempty"`
+	// DisplayCurrency is currency requested by user, cost is in base currency of hotel
+	DisplayCurrency money.Currency `json:"display_currency,omitempty"`
+	// DisplayCost is cost in display currency by exchange rate of price calculation
+	DisplayCost *money.Money `json:"display_cost,omitempty"`
+	// Version is incremented by every saved change. reservation is saved only
+	// if it is not changed by anyone else since it was read
+	Version int64 `json:"version"`
+}
+
+// Copy is deep copy of reservation, so concurrently run jobs of group and storage
+// do not share its maps and slices
+func (r *Reservation) Copy() *Reservation {
+	res := *r
+	res.RoomTypes = append(RoomsRequest(nil), r.RoomTypes...)
+	res.AppliedDiscountIDs = append([]string(nil), r.AppliedDiscountIDs...)
+	if r.Plan != nil {
+		res.Plan = make([]PlanStep, len(r.Plan))
+		for i, step := range r.Plan {
+			res.Plan[i] = append(PlanStep(nil), step...)
+		}
+	}
+	if r.Steps != nil {
+		res.Steps = make(map[ReservationStatus]StepStatus, len(r.Steps))
+		for name, status := range r.Steps {
+			res.Steps[name] = status
+		}
+	}
+	if r.StepAttempts != nil {
+		res.StepAttempts = make(map[ReservationStatus]int, len(r.StepAttempts))
+		for name, attempts := range r.StepAttempts {
+			res.StepAttempts[name] = attempts
+		}
+	}
+	if r.Failure != nil {
+		failure := *r.Failure
+		res.Failure = &failure
+	}
+	if r.Installments != nil {
+		res.Installments = make([]*Installment, len(r.Installments))
+		for i, installment := range r.Installments {
+			copied := *installment
+			res.Installments[i] = &copied
+		}
+	}
+	if r.DisplayCost != nil {
+		displayCost := *r.DisplayCost
+		res.DisplayCost = &displayCost
+	}
+	return &res
 }
 
 type RoomAvailability struct {
@@ -46,6 +106,8 @@ type ReservationRequest struct {
 	PaymentDetails payment.OrderDetails
 	StartDate      time.Time
 	EndDate        time.Time
+	// DisplayCurrency is currency to show cost in, it is base currency of hotel when empty
+	DisplayCurrency money.Currency
 }
 
 type RoomRequest struct {
@@ -1,26 +1,166 @@
 package config
 
-import "time"
+import (
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
 
 type Config struct {
 	Server     Server     `yaml:"server"`
 	Booking    Booking    `yaml:"booking"`
 	Payment    Payment    `yaml:"payment"`
 	Prometheus Prometheus `yaml:"prometheus"`
+	Storage    Storage    `yaml:"storage"`
+	Currency   Currency   `yaml:"currency"`
 }
 
 type Server struct {
 	Port  string `yaml:"port"`
 	Debug bool   `yamls:"debug"`
+	// Idempotency is lifetime of Idempotency-Key headers of requests
+	Idempotency Idempotency `yaml:"idempotency"`
+}
+
+type Idempotency struct {
+	// KeyTTL is time since the first request after which completed key can be reused
+	KeyTTLStr string        `yaml:"keyTTL"`
+	KeyTTL    time.Duration `yaml:"-"`
+	// LeaseTimeout is time since the first request after which key in progress is released,
+	// key stays in progress forever if server is stopped while processing request
+	LeaseTimeoutStr string        `yaml:"leaseTimeout"`
+	LeaseTimeout    time.Duration `yaml:"-"`
+	// CleanupInterval is period of removing expired keys from storage
+	CleanupIntervalStr string        `yaml:"cleanupInterval"`
+	CleanupInterval    time.Duration `yaml:"-"`
 }
 
 type Booking struct {
 	IdleReservationTimeoutStr string        `yaml:"idleReservationTimeout"`
 	IdleReservationTimeout    time.Duration `yaml:"-"`
+	CancelationQueue          Queue         `yaml:"cancelationQueue"`
+	// DeadlineQueue keeps deadlines of asynchronous jobs
+	DeadlineQueue Queue   `yaml:"deadlineQueue"`
+	Retry         Retry   `yaml:"retry"`
+	Failure       Failure `yaml:"failure"`
+	Timeout       Timeout `yaml:"timeout"`
+	Plans         Plans   `yaml:"plans"`
+	// SupportUserIDs are users who can read history of any reservation
+	SupportUserIDs []string `yaml:"supportUserIDs"`
+	// OutboxRelayInterval is period of relaying commands left in outbox, e.g. when request was canceled.
+	// only commands older than interval are relayed, younger ones may be still running
+	OutboxRelayIntervalStr string        `yaml:"outboxRelayInterval"`
+	OutboxRelayInterval    time.Duration `yaml:"-"`
+}
+
+type Plans struct {
+	// Default is plan of users without own plan, all jobs are run if it is not set
+	Default []PlanStep `yaml:"default"`
+	// Named are lists of steps by plan name
+	Named map[string][]PlanStep `yaml:"named"`
+	// Users are plan names by user id
+	Users map[string]string `yaml:"users"`
+}
+
+// PlanStep is job name or list of job names which are run concurrently
+type PlanStep []string
+
+func (p *PlanStep) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*p = PlanStep{value.Value}
+		return nil
+	}
+	return value.Decode((*[]string)(p))
+}
+
+type Retry struct {
+	Default RetryPolicy `yaml:"default"`
+	// Jobs overrides default policy by job name, not set fields are taken from default
+	Jobs map[string]RetryPolicy `yaml:"jobs"`
+}
+
+type Failure struct {
+	// Default is "compensate" to cancel done jobs and reservation or "await_user"
+	// to keep reservation on failed step until user changes it or it becomes idle
+	Default string `yaml:"default"`
+	// Jobs overrides default failure action by job name
+	Jobs map[string]string `yaml:"jobs"`
+}
+
+type Timeout struct {
+	// Default is "rollback" to cancel timed out job and compensate reservation
+	// or "retry" to run job again while retry policy allows it
+	Default string `yaml:"default"`
+	// Jobs overrides default timeout action by job name
+	Jobs map[string]string `yaml:"jobs"`
+}
+
+type RetryPolicy struct {
+	// MaxAttempts is count of job runs including the first one
+	MaxAttempts       int           `yaml:"maxAttempts"`
+	InitialBackoffStr string        `yaml:"initialBackoff"`
+	InitialBackoff    time.Duration `yaml:"-"`
+	MaxBackoffStr     string        `yaml:"maxBackoff"`
+	MaxBackoff        time.Duration `yaml:"-"`
+	Multiplier        float64       `yaml:"multiplier"`
+	// Jitter is part of backoff which is randomized, from 0 to 1
+	Jitter float64 `yaml:"jitter"`
+	// RetryOn is "transient" (default) to retry only errors marked as transient or "any"
+	RetryOn string `yaml:"retryOn"`
+}
+
+type Queue struct {
+	// Path is queue journal file, pending messages are lost on restart if it is empty
+	Path          string        `yaml:"path"`
+	AckTimeoutStr string        `yaml:"ackTimeout"`
+	AckTimeout    time.Duration `yaml:"-"`
 }
 
 type Payment struct {
 	Card Card `yaml:"card"`
+	// OrderDeadline is time to wait for result of pending payment order
+	OrderDeadlineStr string        `yaml:"orderDeadline"`
+	OrderDeadline    time.Duration `yaml:"-"`
+	// CaptureQueue keeps scheduled captures of authorized orders
+	CaptureQueue Queue `yaml:"captureQueue"`
+	// InstallmentQueue keeps scheduled installments of payment schedules
+	InstallmentQueue Queue `yaml:"installmentQueue"`
+	// Schedules are payment schedules by hotel id, whole cost is paid at booking in other hotels
+	Schedules map[string]PaymentSchedule `yaml:"schedules"`
+	// CaptureOnCheckIn are hotels by id where payment is only authorized at booking, amount is captured on start date
+	CaptureOnCheckIn map[string]bool `yaml:"captureOnCheckIn"`
+}
+
+// PaymentSchedule splits cost into deposit paid at booking and balance charged before arrival
+type PaymentSchedule struct {
+	// DepositPercent is part of cost paid at booking, from 1 to 99
+	DepositPercent int `yaml:"depositPercent"`
+	// BalanceBeforeArrival is time before start date when balance is charged.
+	// whole cost is paid at booking if it is already later
+	BalanceBeforeArrivalStr string        `yaml:"balanceBeforeArrival"`
+	BalanceBeforeArrival    time.Duration `yaml:"-"`
+	// OnBalanceFailure is "refund" (default) to cancel reservation and refund deposit
+	// or "keep_deposit" to cancel reservation keeping deposit as penalty
+	OnBalanceFailure string `yaml:"onBalanceFailure"`
+}
+
+// Currency is base currency of hotels, costs and payments of hotel are in its base currency
+type Currency struct {
+	// Default is base currency of hotels without own one
+	Default string `yaml:"default"`
+	// Hotels are base currencies by hotel id
+	Hotels map[string]string `yaml:"hotels"`
+	// RatesPath is file of exchange rates to display costs in other currencies,
+	// costs are shown in base currencies only when it is empty
+	RatesPath string `yaml:"ratesPath"`
+}
+
+// HotelCurrency is base currency of hotel
+func (c Currency) HotelCurrency(hotelID string) string {
+	if currency, ok := c.Hotels[hotelID]; ok {
+		return currency
+	}
+	return c.Default
 }
 
 type Prometheus struct {
@@ -29,6 +169,33 @@ type Prometheus struct {
 }
 
 type Card struct {
+	// Timeout is delay of simulated order result, it is not used when webhook is enabled
 	TimeoutStr string        `yaml:"timeout"`
 	Timeout    time.Duration `yaml:"-"`
+	// WebhookSecret enables acquirer callbacks signed by this secret instead of simulated results
+	WebhookSecret string `yaml:"webhookSecret"`
+}
+
+type Storage struct {
+	// Type is name of repository backend: "inmemory" (default), "postgres" or "bolt"
+	Type     string   `yaml:"type"`
+	InMemory InMemory `yaml:"inmemory"`
+	Postgres Postgres `yaml:"postgres"`
+	Bolt     Bolt     `yaml:"bolt"`
+}
+
+type InMemory struct {
+	// JournalDir enables write-ahead log and snapshots when not empty
+	JournalDir          string        `yaml:"journalDir"`
+	SnapshotIntervalStr string        `yaml:"snapshotInterval"`
+	SnapshotInterval    time.Duration `yaml:"-"`
+}
+
+type Postgres struct {
+	DSN string `yaml:"dsn"`
+}
+
+type Bolt struct {
+	// Path is database file, it is created if not exists
+	Path string `yaml:"path"`
 }
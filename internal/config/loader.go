@@ -46,6 +46,27 @@ func (c *Loader) loadFile() error {
 	if err = yaml.Unmarshal(configBytes, &c.data); err != nil {
 		return err
 	}
+	if duration, err := time.ParseDuration(c.data.Server.Idempotency.KeyTTLStr); err != nil {
+		c.data.Server.Idempotency.KeyTTL = time.Hour * 24
+		c.data.Server.Idempotency.KeyTTLStr = c.data.Server.Idempotency.KeyTTL.String()
+	} else {
+		c.data.Server.Idempotency.KeyTTL = duration
+	}
+
+	if duration, err := time.ParseDuration(c.data.Server.Idempotency.LeaseTimeoutStr); err != nil {
+		c.data.Server.Idempotency.LeaseTimeout = time.Minute * 5
+		c.data.Server.Idempotency.LeaseTimeoutStr = c.data.Server.Idempotency.LeaseTimeout.String()
+	} else {
+		c.data.Server.Idempotency.LeaseTimeout = duration
+	}
+
+	if duration, err := time.ParseDuration(c.data.Server.Idempotency.CleanupIntervalStr); err != nil || duration <= 0 {
+		c.data.Server.Idempotency.CleanupInterval = time.Minute * 10
+		c.data.Server.Idempotency.CleanupIntervalStr = c.data.Server.Idempotency.CleanupInterval.String()
+	} else {
+		c.data.Server.Idempotency.CleanupInterval = duration
+	}
+
 	if duration, err := time.ParseDuration(c.data.Booking.IdleReservationTimeoutStr); err != nil {
 		c.data.Booking.IdleReservationTimeout = time.Minute * 30
 		c.data.Booking.IdleReservationTimeoutStr = c.data.Booking.IdleReservationTimeout.String()
@@ -53,6 +74,50 @@ func (c *Loader) loadFile() error {
 		c.data.Booking.IdleReservationTimeout = duration
 	}
 
+	if duration, err := time.ParseDuration(c.data.Booking.CancelationQueue.AckTimeoutStr); err != nil {
+		c.data.Booking.CancelationQueue.AckTimeout = time.Minute
+		c.data.Booking.CancelationQueue.AckTimeoutStr = c.data.Booking.CancelationQueue.AckTimeout.String()
+	} else {
+		c.data.Booking.CancelationQueue.AckTimeout = duration
+	}
+
+	if duration, err := time.ParseDuration(c.data.Booking.DeadlineQueue.AckTimeoutStr); err != nil {
+		c.data.Booking.DeadlineQueue.AckTimeout = time.Minute
+		c.data.Booking.DeadlineQueue.AckTimeoutStr = c.data.Booking.DeadlineQueue.AckTimeout.String()
+	} else {
+		c.data.Booking.DeadlineQueue.AckTimeout = duration
+	}
+
+	if duration, err := time.ParseDuration(c.data.Booking.OutboxRelayIntervalStr); err != nil || duration <= 0 {
+		c.data.Booking.OutboxRelayInterval = time.Second * 30
+		c.data.Booking.OutboxRelayIntervalStr = c.data.Booking.OutboxRelayInterval.String()
+	} else {
+		c.data.Booking.OutboxRelayInterval = duration
+	}
+
+	c.data.Booking.Retry.Default = c.data.Booking.Retry.Default.withDefaults(defaultRetryPolicy)
+	for name, policy := range c.data.Booking.Retry.Jobs {
+		c.data.Booking.Retry.Jobs[name] = policy.withDefaults(c.data.Booking.Retry.Default)
+	}
+
+	if !isFailureAction(c.data.Booking.Failure.Default) {
+		c.data.Booking.Failure.Default = "compensate"
+	}
+	for name, action := range c.data.Booking.Failure.Jobs {
+		if !isFailureAction(action) {
+			c.data.Booking.Failure.Jobs[name] = c.data.Booking.Failure.Default
+		}
+	}
+
+	if !isTimeoutAction(c.data.Booking.Timeout.Default) {
+		c.data.Booking.Timeout.Default = "rollback"
+	}
+	for name, action := range c.data.Booking.Timeout.Jobs {
+		if !isTimeoutAction(action) {
+			c.data.Booking.Timeout.Jobs[name] = c.data.Booking.Timeout.Default
+		}
+	}
+
 	if duration, err := time.ParseDuration(c.data.Payment.Card.TimeoutStr); err != nil {
 		c.data.Payment.Card.Timeout = time.Minute * 30
 		c.data.Payment.Card.TimeoutStr = c.data.Payment.Card.Timeout.String()
@@ -60,6 +125,61 @@ func (c *Loader) loadFile() error {
 		c.data.Payment.Card.Timeout = duration
 	}
 
+	if duration, err := time.ParseDuration(c.data.Payment.CaptureQueue.AckTimeoutStr); err != nil {
+		c.data.Payment.CaptureQueue.AckTimeout = time.Minute
+		c.data.Payment.CaptureQueue.AckTimeoutStr = c.data.Payment.CaptureQueue.AckTimeout.String()
+	} else {
+		c.data.Payment.CaptureQueue.AckTimeout = duration
+	}
+
+	if duration, err := time.ParseDuration(c.data.Payment.InstallmentQueue.AckTimeoutStr); err != nil {
+		c.data.Payment.InstallmentQueue.AckTimeout = time.Minute
+		c.data.Payment.InstallmentQueue.AckTimeoutStr = c.data.Payment.InstallmentQueue.AckTimeout.String()
+	} else {
+		c.data.Payment.InstallmentQueue.AckTimeout = duration
+	}
+
+	for hotelID, schedule := range c.data.Payment.Schedules {
+		if duration, err := time.ParseDuration(schedule.BalanceBeforeArrivalStr); err != nil {
+			schedule.BalanceBeforeArrival = time.Hour * 24 * 7
+			schedule.BalanceBeforeArrivalStr = schedule.BalanceBeforeArrival.String()
+		} else {
+			schedule.BalanceBeforeArrival = duration
+		}
+		if schedule.OnBalanceFailure != "keep_deposit" {
+			schedule.OnBalanceFailure = "refund"
+		}
+		c.data.Payment.Schedules[hotelID] = schedule
+	}
+
+	if duration, err := time.ParseDuration(c.data.Payment.OrderDeadlineStr); err != nil {
+		c.data.Payment.OrderDeadline = c.data.Booking.IdleReservationTimeout / 2
+		c.data.Payment.OrderDeadlineStr = c.data.Payment.OrderDeadline.String()
+	} else {
+		c.data.Payment.OrderDeadline = duration
+	}
+	// idle reservation is canceled before pending order deadline otherwise
+	if c.data.Payment.OrderDeadline >= c.data.Booking.IdleReservationTimeout {
+		return fmt.Errorf("payment.orderDeadline %s must be less than booking.idleReservationTimeout %s",
+			c.data.Payment.OrderDeadlineStr, c.data.Booking.IdleReservationTimeoutStr)
+	}
+
+	if duration, err := time.ParseDuration(c.data.Storage.InMemory.SnapshotIntervalStr); err != nil {
+		c.data.Storage.InMemory.SnapshotInterval = time.Minute * 5
+		c.data.Storage.InMemory.SnapshotIntervalStr = c.data.Storage.InMemory.SnapshotInterval.String()
+	} else {
+		c.data.Storage.InMemory.SnapshotInterval = duration
+	}
+
+	if !isCurrency(c.data.Currency.Default) {
+		c.data.Currency.Default = "USD"
+	}
+	for hotelID, currency := range c.data.Currency.Hotels {
+		if !isCurrency(currency) {
+			c.data.Currency.Hotels[hotelID] = c.data.Currency.Default
+		}
+	}
+
 	if _, err := strconv.ParseUint(c.data.Prometheus.Port, 10, 32); err != nil {
 		c.data.Prometheus.Port = "2112"
 	}
@@ -70,6 +190,67 @@ func (c *Loader) loadFile() error {
 	return nil
 }
 
+func isFailureAction(action string) bool {
+	return action == "compensate" || action == "await_user"
+}
+
+func isTimeoutAction(action string) bool {
+	return action == "rollback" || action == "retry"
+}
+
+// isCurrency checks if currency looks like ISO 4217 code
+func isCurrency(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+	for _, ch := range currency {
+		if ch < 'A' || ch > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts:       3,
+	InitialBackoffStr: "1s",
+	InitialBackoff:    time.Second,
+	MaxBackoffStr:     "30s",
+	MaxBackoff:        time.Second * 30,
+	Multiplier:        2,
+	Jitter:            0.2,
+	RetryOn:           "transient",
+}
+
+// withDefaults parses durations and fills not set fields from defaults
+func (p RetryPolicy) withDefaults(defaults RetryPolicy) RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaults.MaxAttempts
+	}
+	if duration, err := time.ParseDuration(p.InitialBackoffStr); err != nil {
+		p.InitialBackoff = defaults.InitialBackoff
+		p.InitialBackoffStr = defaults.InitialBackoffStr
+	} else {
+		p.InitialBackoff = duration
+	}
+	if duration, err := time.ParseDuration(p.MaxBackoffStr); err != nil {
+		p.MaxBackoff = defaults.MaxBackoff
+		p.MaxBackoffStr = defaults.MaxBackoffStr
+	} else {
+		p.MaxBackoff = duration
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = defaults.Multiplier
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = defaults.Jitter
+	}
+	if p.RetryOn == "" {
+		p.RetryOn = defaults.RetryOn
+	}
+	return p
+}
+
 func (c *Loader) Start(ctx context.Context) error {
 	if err := c.loadFile(); err != nil {
 		return fmt.Errorf("loading config file: %s", err.Error())
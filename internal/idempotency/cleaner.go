@@ -0,0 +1,57 @@
+package idempotency
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+)
+
+// ExpirationFromConfig is expiration of keys set in server config
+func ExpirationFromConfig(cnf *config.Config) Expiration {
+	return Expiration{
+		TTL:   cnf.Server.Idempotency.KeyTTL,
+		Lease: cnf.Server.Idempotency.LeaseTimeout,
+	}
+}
+
+// Cleaner periodically removes expired keys, so storage does not grow with every request
+type Cleaner struct {
+	cnf    *config.Config
+	store  Store
+	doneCh chan struct{}
+}
+
+func NewCleaner(cnf *config.Config, store Store) *Cleaner {
+	return &Cleaner{
+		cnf:    cnf,
+		store:  store,
+		doneCh: make(chan struct{}),
+	}
+}
+
+func (c *Cleaner) Start(ctx context.Context) error {
+	go func() {
+		t := time.NewTicker(c.cnf.Server.Idempotency.CleanupInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				if err := c.store.DeleteExpiredIdempotencyKeys(context.Background(), ExpirationFromConfig(c.cnf), time.Now()); err != nil {
+					log.Printf("[idempotency] cleanup failed: %s", err.Error())
+				}
+			case <-c.doneCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (c *Cleaner) Stop(ctx context.Context) error {
+	close(c.doneCh)
+	return nil
+}
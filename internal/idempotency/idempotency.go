@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrKeyExists = errors.New("idempotency key is already used")
+
+// Record is idempotency key of user with fingerprint of the first request and its response.
+// status code is zero while the first request is being processed
+type Record struct {
+	UserID      string    `json:"user_id"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	StatusCode  int       `json:"status_code"`
+	Body        []byte    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IsCompleted tells if response of the first request is saved
+func (r *Record) IsCompleted() bool {
+	return r.StatusCode != 0
+}
+
+// Expiration tells when stored keys are released. zero duration never releases keys
+type Expiration struct {
+	// TTL is time since the first request after which completed key is released
+	TTL time.Duration
+	// Lease is time since the first request after which key in progress is released,
+	// response is never saved if server is stopped while processing request
+	Lease time.Duration
+}
+
+// Cutoffs are creation times before which completed keys and keys in progress are expired
+func (e Expiration) Cutoffs(now time.Time) (completedBefore, inProgressBefore time.Time) {
+	if e.TTL > 0 {
+		completedBefore = now.Add(-e.TTL)
+	}
+	if e.Lease > 0 {
+		inProgressBefore = now.Add(-e.Lease)
+	}
+	return completedBefore, inProgressBefore
+}
+
+// IsExpired tells if stored record is released at given time
+func (e Expiration) IsExpired(r *Record, now time.Time) bool {
+	completedBefore, inProgressBefore := e.Cutoffs(now)
+	if r.IsCompleted() {
+		return r.CreatedAt.Before(completedBefore)
+	}
+	return r.CreatedAt.Before(inProgressBefore)
+}
+
+// Store keeps idempotency keys. keys of different users don't intersect
+type Store interface {
+	// CreateIdempotencyKey saves record of new key. if key is already used,
+	// it returns stored record and ErrKeyExists. stored record which is expired
+	// at creation time of new one is replaced
+	CreateIdempotencyKey(ctx context.Context, record *Record, expiration Expiration) (*Record, error)
+
+	// CompleteIdempotencyKey saves response of the first request
+	CompleteIdempotencyKey(ctx context.Context, record *Record) error
+
+	// DeleteIdempotencyKey releases key, so request can be made again
+	DeleteIdempotencyKey(ctx context.Context, userID, key string) error
+
+	// DeleteExpiredIdempotencyKeys removes keys which are expired at given time
+	DeleteExpiredIdempotencyKeys(ctx context.Context, expiration Expiration, now time.Time) error
+}
@@ -0,0 +1,72 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrCurrencyMismatch is returned when amounts of different currencies are combined
+var ErrCurrencyMismatch = errors.New("currencies of amounts do not match")
+
+// Currency is ISO 4217 code of currency, e.g. "USD"
+type Currency string
+
+// IsValidCurrency checks if currency looks like ISO 4217 code, it does not check if currency exists
+func IsValidCurrency(c Currency) bool {
+	if len(c) != 3 {
+		return false
+	}
+	for _, ch := range c {
+		if ch < 'A' || ch > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// minorUnits are digits after decimal point of currencies which don't have cents
+var minorUnits = map[Currency]int{
+	"BHD": 3, "CLP": 0, "ISK": 0, "JOD": 3, "JPY": 0,
+	"KRW": 0, "KWD": 3, "OMR": 3, "TND": 3, "VND": 0,
+}
+
+// MinorUnits is count of digits after decimal point of currency, it is 2 for most of them
+func (c Currency) MinorUnits() int {
+	if digits, ok := minorUnits[c]; ok {
+		return digits
+	}
+	return 2
+}
+
+// Money is amount in minor units of currency, e.g. cents
+type Money struct {
+	Amount   int      `json:"amount"`
+	Currency Currency `json:"currency"`
+}
+
+func New(amount int, currency Currency) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Percent returns part of amount, it is rounded down to minor unit
+func (m Money) Percent(percent int) Money {
+	return New(m.Amount*percent/100, m.Currency)
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+}
+
+// UnmarshalJSON reads amount object. plain number is amount without currency,
+// amounts were saved this way before currencies were introduced
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var amount int
+	if err := json.Unmarshal(data, &amount); err == nil {
+		*m = Money{Amount: amount}
+		return nil
+	}
+
+	type money Money
+	return json.Unmarshal(data, (*money)(m))
+}
@@ -0,0 +1,71 @@
+package money
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+)
+
+func TestConvert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.yml")
+	if err := os.WriteFile(path, []byte("base: USD\nrates:\n  EUR: 0.9\n  JPY: 150\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rates := NewStaticRateProvider(&config.Config{Currency: config.Currency{RatesPath: path}})
+	if err := rates.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		in      Money
+		to      Currency
+		want    Money
+		wantErr error
+	}{
+		{name: "same currency", in: New(1500, "CHF"), to: "CHF", want: New(1500, "CHF")},
+		{name: "from base", in: New(1500, "USD"), to: "EUR", want: New(1350, "EUR")},
+		{name: "cross rate", in: New(900, "EUR"), to: "JPY", want: New(1500, "JPY")},
+		{name: "to currency with cents", in: New(150, "JPY"), to: "USD", want: New(100, "USD")},
+		{name: "unknown currency", in: New(1500, "USD"), to: "CHF", wantErr: ErrUnknownRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Convert(context.Background(), rates, tt.in, tt.to)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Convert() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Convert() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoney_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want Money
+	}{
+		{name: "amount object", in: `{"amount": 1500, "currency": "EUR"}`, want: New(1500, "EUR")},
+		// amounts were saved without currency before
+		{name: "plain number", in: `1500`, want: New(1500, "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Money{}
+			if err := json.Unmarshal([]byte(tt.in), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
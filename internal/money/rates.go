@@ -0,0 +1,108 @@
+package money
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownRate is returned when there is no exchange rate between currencies
+var ErrUnknownRate = errors.New("exchange rate is unknown")
+
+// RateProvider provides exchange rates, e.g. of bank or FX data vendor
+type RateProvider interface {
+	// Rate is how many units of target currency one unit of source currency costs
+	Rate(ctx context.Context, from, to Currency) (float64, error)
+}
+
+// Convert converts amount to currency by rate of provider, result is rounded to minor unit
+func Convert(ctx context.Context, rates RateProvider, m Money, to Currency) (Money, error) {
+	if m.Currency == to {
+		return m, nil
+	}
+
+	rate, err := rates.Rate(ctx, m.Currency, to)
+	if err != nil {
+		return Money{}, err
+	}
+
+	// rate is for whole units, but amounts are in minor ones
+	scale := math.Pow10(to.MinorUnits() - m.Currency.MinorUnits())
+	return New(int(math.Round(float64(m.Amount)*rate*scale)), to), nil
+}
+
+// StaticRateProvider is stand-in of real rates provider, it takes rates from file
+type StaticRateProvider struct {
+	cnf *config.Config
+	// rates are how many units of currency one unit of base currency costs
+	rates map[Currency]float64
+	mux   sync.RWMutex
+}
+
+// staticRates is format of rates file
+type staticRates struct {
+	Base  Currency             `yaml:"base"`
+	Rates map[Currency]float64 `yaml:"rates"`
+}
+
+func NewStaticRateProvider(cnf *config.Config) *StaticRateProvider {
+	return &StaticRateProvider{cnf: cnf, rates: map[Currency]float64{}}
+}
+
+func (p *StaticRateProvider) Rate(_ context.Context, from, to Currency) (float64, error) {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	fromRate, fromOk := p.rates[from]
+	toRate, toOk := p.rates[to]
+	if !fromOk || !toOk {
+		return 0, fmt.Errorf("%w: %s to %s", ErrUnknownRate, from, to)
+	}
+	return toRate / fromRate, nil
+}
+
+// loadFile reads rates file, only conversions to the same currency are known without it
+func (p *StaticRateProvider) loadFile() error {
+	rates := map[Currency]float64{}
+
+	if path := p.cnf.Currency.RatesPath; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		file := staticRates{}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return err
+		}
+		for currency, rate := range file.Rates {
+			if !IsValidCurrency(currency) || rate <= 0 {
+				return fmt.Errorf("invalid rate of %q", currency)
+			}
+			rates[currency] = rate
+		}
+		rates[file.Base] = 1
+	}
+
+	p.mux.Lock()
+	p.rates = rates
+	p.mux.Unlock()
+
+	return nil
+}
+
+func (p *StaticRateProvider) Start(_ context.Context) error {
+	if err := p.loadFile(); err != nil {
+		return fmt.Errorf("loading rates file: %s", err.Error())
+	}
+	return nil
+}
+
+func (p *StaticRateProvider) Stop(_ context.Context) error {
+	return nil
+}
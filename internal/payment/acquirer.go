@@ -0,0 +1,56 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// FakeAcquirer calls card payment webhook like real acquirer does when order is paid or declined.
+// it is used in tests and local runs
+type FakeAcquirer struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewFakeAcquirer makes acquirer which sends signed callbacks to webhook url
+func NewFakeAcquirer(url, secret string) *FakeAcquirer {
+	return &FakeAcquirer{
+		url:    url,
+		secret: secret,
+		client: &http.Client{},
+	}
+}
+
+// Notify sends new state of reservation order
+func (a *FakeAcquirer) Notify(ctx context.Context, msg CardWebhookPayload) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(a.secret, timestamp, payload))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
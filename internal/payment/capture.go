@@ -0,0 +1,69 @@
+package payment
+
+import (
+	"context"
+	"errors"
+
+	"github.com/antnmxmv/booking-service/internal/money"
+)
+
+var (
+	ErrCaptureNotSupported = errors.New("payment source does not support authorization holds")
+	ErrNotAuthorized       = errors.New("payment order is not authorized")
+)
+
+// authorizingSource is source which can hold amount at booking and charge it later
+type authorizingSource interface {
+	// authorizeOrder creates order which holds amount, it becomes authorized instead of paid
+	authorizeOrder(ctx context.Context, reservationID string, amount money.Money, details OrderDetails, idempotencyKey string) (Order, error)
+	// captureOrder charges held amount of authorized order
+	captureOrder(ctx context.Context, order Order, idempotencyKey string) (Order, error)
+	// voidOrder releases held amount of authorized order
+	voidOrder(ctx context.Context, order Order) (Order, error)
+}
+
+func (p *Provider) authorizingSource(sourceType SourceType, order Order) (authorizingSource, error) {
+	source, ok := p.sources[sourceType].(authorizingSource)
+	if !ok {
+		return nil, ErrCaptureNotSupported
+	}
+	if order == nil || order.Status() != PaymentStatusAuthorized {
+		return nil, ErrNotAuthorized
+	}
+	return source, nil
+}
+
+// AuthorizeOrder creates order which holds amount until it is captured or voided.
+// retry with the same idempotency key returns already created order
+func (p *Provider) AuthorizeOrder(ctx context.Context, reservationID string, amount money.Money, sourceType SourceType, details OrderDetails, idempotencyKey string) (Order, error) {
+	source, ok := p.sources[sourceType].(authorizingSource)
+	if !ok {
+		return nil, ErrCaptureNotSupported
+	}
+	order, err := source.authorizeOrder(ctx, reservationID, amount, details, idempotencyKey)
+	if err != nil {
+		return nil, sourceError(err)
+	}
+	return order, nil
+}
+
+// CaptureOrder charges amount held by authorized order.
+// retry with the same idempotency key returns already captured order
+func (p *Provider) CaptureOrder(ctx context.Context, sourceType SourceType, order Order, idempotencyKey string) (Order, error) {
+	source, err := p.authorizingSource(sourceType, order)
+	if err != nil {
+		return nil, err
+	}
+	captured, err := source.captureOrder(ctx, order, idempotencyKey)
+	return captured, sourceError(err)
+}
+
+// VoidOrder releases amount held by authorized order, order becomes canceled
+func (p *Provider) VoidOrder(ctx context.Context, sourceType SourceType, order Order) (Order, error) {
+	source, err := p.authorizingSource(sourceType, order)
+	if err != nil {
+		return nil, err
+	}
+	voided, err := source.voidOrder(ctx, order)
+	return voided, sourceError(err)
+}
@@ -0,0 +1,46 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
+)
+
+func TestProvider_CaptureOrder(t *testing.T) {
+	ctx := context.Background()
+	p := NewPaymentProvider(NewCashSource(), NewCardSource(&config.Config{}))
+
+	authorized := cardPaymentOrder{RID: "1", PaymentStatus: PaymentStatusAuthorized, OrderAmount: 100, OrderCurrency: "EUR"}
+
+	if _, err := p.CaptureOrder(ctx, "cash", &cashPaymentOrder{RID: "1", PaymentStatus: PaymentStatusAuthorized}, ""); !errors.Is(err, ErrCaptureNotSupported) {
+		t.Errorf("capture of cash order error = %v, want %v", err, ErrCaptureNotSupported)
+	}
+	if _, err := p.CaptureOrder(ctx, "card", cardPaymentOrder{RID: "1", PaymentStatus: PaymentStatusSuccess}, ""); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("capture of paid order error = %v, want %v", err, ErrNotAuthorized)
+	}
+
+	captured, err := p.CaptureOrder(ctx, "card", authorized, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if captured.Status() != PaymentStatusCaptured || captured.Amount() != money.New(100, "EUR") {
+		t.Errorf("captured order = %s %s, want %s 100 EUR", captured.Status(), captured.Amount(), PaymentStatusCaptured)
+	}
+	if !IsRefundable(captured.Status()) {
+		t.Error("captured order is not refundable")
+	}
+
+	voided, err := p.VoidOrder(ctx, "card", authorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if voided.Status() != PaymentStatusCanceled {
+		t.Errorf("voided order status = %s, want %s", voided.Status(), PaymentStatusCanceled)
+	}
+	if _, err := p.VoidOrder(ctx, "card", captured); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("void of captured order error = %v, want %v", err, ErrNotAuthorized)
+	}
+}
@@ -11,11 +11,13 @@ import (
 	"time"
 
 	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
 )
 
 // CardSource is asynchronious payment source. It sends a random state other than 'pending' with delay
 // this is example of payment source can create order for already known credentials by their id
-// and for sms verification it generates link to web form
+// and for sms verification it generates link to web form.
+// when webhook is enabled, results come from acquirer callbacks instead
 type CardSource struct {
 	cnf       *config.Config
 	lastId    uint
@@ -23,7 +25,13 @@ type CardSource struct {
 	// ordersCancelingChans is map of doneCh for every pending order
 	// we need it to handle order cancelation just for example
 	ordersCancelingChans map[string]chan struct{}
-	mux                  sync.Mutex
+	// ordersByKey is last known state of orders by idempotency key
+	ordersByKey map[string]cardPaymentOrder
+	mux         sync.Mutex
+	// createdOrders are orders by id, webhooks about other orders are rejected
+	createdOrders map[string]cardPaymentOrder
+	// orderKeys are idempotency keys of order creation by order id
+	orderKeys map[string]string
 }
 
 func (cp *CardSource) subscribe() <-chan Order {
@@ -37,6 +45,9 @@ func NewCardSource(cnf *config.Config) *CardSource {
 		lastId:               0,
 		updatesCh:            make(chan Order),
 		ordersCancelingChans: make(map[string]chan struct{}),
+		ordersByKey:          make(map[string]cardPaymentOrder),
+		createdOrders:        make(map[string]cardPaymentOrder),
+		orderKeys:            make(map[string]string),
 	}
 }
 
@@ -44,7 +55,16 @@ func (cp *CardSource) name() SourceType {
 	return "card"
 }
 
-func (cp *CardSource) createOrder(reservationID string, amount int, details OrderDetails) (Order, error) {
+func (cp *CardSource) createOrder(ctx context.Context, reservationID string, amount money.Money, details OrderDetails, idempotencyKey string) (Order, error) {
+	return cp.newOrder(ctx, reservationID, amount, details, idempotencyKey, false)
+}
+
+// authorizeOrder creates order which only holds amount until it is captured or voided
+func (cp *CardSource) authorizeOrder(ctx context.Context, reservationID string, amount money.Money, details OrderDetails, idempotencyKey string) (Order, error) {
+	return cp.newOrder(ctx, reservationID, amount, details, idempotencyKey, true)
+}
+
+func (cp *CardSource) newOrder(ctx context.Context, reservationID string, amount money.Money, details OrderDetails, idempotencyKey string, hold bool) (Order, error) {
 	// type assertion
 	request, ok := details.(cardOrderDetails)
 	if !ok {
@@ -54,6 +74,16 @@ func (cp *CardSource) createOrder(reservationID string, amount int, details Orde
 	cp.mux.Lock()
 	defer cp.mux.Unlock()
 
+	// here would be request to merchant, order is not created if caller is gone
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// merchant does not create second order with the same key
+	if order, ok := cp.ordersByKey[idempotencyKey]; ok && idempotencyKey != "" {
+		return &order, nil
+	}
+
 	defer func() { cp.lastId += 1 }()
 	paymentLink := fmt.Sprintf("http://merchant-url/card/%d/order/%d", request.CardID, cp.lastId+1)
 
@@ -62,14 +92,30 @@ func (cp *CardSource) createOrder(reservationID string, amount int, details Orde
 		Comment:       "it will randomly become successful or failed in 5 seconds",
 		PaymentStatus: PaymentStatusPending,
 		RID:           reservationID,
+		OrderAmount:   amount.Amount,
+		OrderCurrency: amount.Currency,
+	}
+	if idempotencyKey != "" {
+		cp.orderKeys[reservationID] = idempotencyKey
+	}
+	cp.saveOrder(*res)
+
+	if cp.webhookSecret() != "" {
+		res.Comment = "waiting for acquirer callback"
+		return res, nil
 	}
-	cp.ordersCancelingChans[reservationID] = make(chan struct{})
+
+	cancelCh := make(chan struct{})
+	cp.ordersCancelingChans[reservationID] = cancelCh
 
 	go func(order cardPaymentOrder) {
 
 		if rand.Int()%2 == 0 {
 			order.PaymentStatus = PaymentStatusFailed
 			order.Comment = "transfer declined"
+		} else if hold {
+			order.PaymentStatus = PaymentStatusAuthorized
+			order.Comment = "amount is held until check-in"
 		} else {
 			order.PaymentStatus = PaymentStatusSuccess
 			order.Comment = "transfer accepted"
@@ -78,9 +124,12 @@ func (cp *CardSource) createOrder(reservationID string, amount int, details Orde
 		t := time.NewTimer(cp.cnf.Payment.Card.Timeout)
 		select {
 		case <-t.C:
+			cp.mux.Lock()
 			delete(cp.ordersCancelingChans, reservationID)
+			cp.saveOrder(order)
+			cp.mux.Unlock()
 			cp.updatesCh <- order
-		case <-cp.ordersCancelingChans[reservationID]:
+		case <-cancelCh:
 			t.Stop()
 		}
 	}(*res)
@@ -88,18 +137,215 @@ func (cp *CardSource) createOrder(reservationID string, amount int, details Orde
 	return res, nil
 }
 
-func (cp *CardSource) cancelOrder(reservationID string) (Order, error) {
+func (cp *CardSource) cancelOrder(ctx context.Context, reservationID string) (Order, error) {
 	cp.mux.Lock()
 	defer cp.mux.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if ch, ok := cp.ordersCancelingChans[reservationID]; ok {
 		close(ch)
+		delete(cp.ordersCancelingChans, reservationID)
 	}
-	return cardPaymentOrder{
+	res := cardPaymentOrder{
 		RID:           reservationID,
 		URL:           "",
 		PaymentStatus: PaymentStatusCanceled,
 		Comment:       "",
-	}, nil
+	}
+	if created, ok := cp.createdOrders[reservationID]; ok {
+		res.OrderAmount, res.OrderCurrency = created.OrderAmount, created.OrderCurrency
+		cp.saveOrder(res)
+	}
+	return res, nil
+}
+
+// saveOrder saves new state of created order, so retry of its creation with the same
+// idempotency key returns it. called under lock
+func (cp *CardSource) saveOrder(order cardPaymentOrder) {
+	cp.createdOrders[order.RID] = order
+	if key, ok := cp.orderKeys[order.RID]; ok {
+		cp.ordersByKey[key] = order
+	}
+}
+
+func (cp *CardSource) webhookSecret() string {
+	return cp.cnf.Payment.Card.WebhookSecret
+}
+
+// CardWebhookPayload is body of acquirer callback about order payment or refund
+type CardWebhookPayload struct {
+	ReservationID string        `json:"reservation_id"`
+	Status        PaymentStatus `json:"status"`
+	Comment       string        `json:"comment"`
+	Amount        int           `json:"amount"`
+	// Refunded is total amount of completed refunds
+	Refunded int `json:"refunded,omitempty"`
+	// Currency is currency of amounts
+	Currency money.Currency `json:"currency,omitempty"`
+}
+
+func (cp *CardSource) handleWebhook(ctx context.Context, payload []byte) (Order, error) {
+	msg := CardWebhookPayload{}
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.ReservationID == "" {
+		return nil, ErrInvalidWebhook
+	}
+
+	switch msg.Status {
+	case PaymentStatusSuccess, PaymentStatusFailed, PaymentStatusCanceled, PaymentStatusAuthorized, PaymentStatusCaptured,
+		PaymentStatusPartiallyRefunded, PaymentStatusRefunded:
+	default:
+		return nil, fmt.Errorf("%w: unexpected status %q", ErrInvalidWebhook, msg.Status)
+	}
+
+	order := cardPaymentOrder{
+		RID:           msg.ReservationID,
+		PaymentStatus: msg.Status,
+		Comment:       msg.Comment,
+		OrderAmount:   msg.Amount,
+		Refunded:      msg.Refunded,
+		OrderCurrency: msg.Currency,
+	}
+
+	if err := cp.applyWebhook(order); err != nil {
+		return nil, err
+	}
+
+	select {
+	case cp.updatesCh <- order:
+		return order, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// applyWebhook checks that webhook is about known order and saves its new state,
+// so retry of order creation with the same idempotency key returns it
+func (cp *CardSource) applyWebhook(order cardPaymentOrder) error {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	stored, ok := cp.createdOrders[order.RID]
+	if !ok {
+		return fmt.Errorf("%w: unknown order %s", ErrInvalidWebhook, order.RID)
+	}
+	if stored.OrderAmount != order.OrderAmount || stored.OrderCurrency != order.OrderCurrency {
+		return fmt.Errorf("%w: amount %s does not match order amount %s", ErrInvalidWebhook, order.Amount(), stored.Amount())
+	}
+	if order.Refunded < 0 || order.Refunded > order.OrderAmount {
+		return fmt.Errorf("%w: refunded amount %d is out of order amount", ErrInvalidWebhook, order.Refunded)
+	}
+
+	cp.saveOrder(order)
+	return nil
+}
+
+// refundOrder asks acquirer to return money. result comes with delay like result of payment
+func (cp *CardSource) refundOrder(ctx context.Context, order Order, amount money.Money, idempotencyKey string) (Order, error) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if refund, ok := cp.ordersByKey[idempotencyKey]; ok && idempotencyKey != "" {
+		return &refund, nil
+	}
+
+	res := toCardOrder(order)
+	res.PaymentStatus = PaymentStatusRefundPending
+	res.Comment = "refund is being processed"
+	res.RefundPending = amount.Amount
+	if idempotencyKey != "" {
+		cp.ordersByKey[idempotencyKey] = *res
+	}
+
+	if cp.webhookSecret() != "" {
+		return res, nil
+	}
+
+	go func(refund cardPaymentOrder) {
+		refund.Refunded += refund.RefundPending
+		refund.RefundPending = 0
+		refund.PaymentStatus = refundedStatus(refund.OrderAmount, refund.Refunded)
+		refund.Comment = "refund completed"
+
+		time.Sleep(cp.cnf.Payment.Card.Timeout)
+
+		cp.mux.Lock()
+		if idempotencyKey != "" {
+			cp.ordersByKey[idempotencyKey] = refund
+		}
+		cp.mux.Unlock()
+		cp.updatesCh <- refund
+	}(*res)
+
+	return res, nil
+}
+
+// captureOrder charges held amount. merchant answers immediately, so capture is synchronious
+func (cp *CardSource) captureOrder(ctx context.Context, order Order, idempotencyKey string) (Order, error) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if captured, ok := cp.ordersByKey[idempotencyKey]; ok && idempotencyKey != "" {
+		return &captured, nil
+	}
+
+	res := toCardOrder(order)
+	res.PaymentStatus = PaymentStatusCaptured
+	res.Comment = "held amount is charged"
+	if idempotencyKey != "" {
+		cp.ordersByKey[idempotencyKey] = *res
+	}
+	if _, ok := cp.createdOrders[res.RID]; ok {
+		cp.saveOrder(*res)
+	}
+
+	return res, nil
+}
+
+// voidOrder releases held amount, nothing is charged
+func (cp *CardSource) voidOrder(ctx context.Context, order Order) (Order, error) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	res := toCardOrder(order)
+	res.PaymentStatus = PaymentStatusCanceled
+	res.Comment = "held amount is released"
+	if _, ok := cp.createdOrders[res.RID]; ok {
+		cp.saveOrder(*res)
+	}
+
+	return res, nil
+}
+
+// toCardOrder copies order of card source
+func toCardOrder(order Order) *cardPaymentOrder {
+	switch o := order.(type) {
+	case cardPaymentOrder:
+		return &o
+	case *cardPaymentOrder:
+		res := *o
+		return &res
+	}
+	return &cardPaymentOrder{
+		RID:           order.ReservationID(),
+		PaymentStatus: order.Status(),
+		OrderAmount:   order.Amount().Amount,
+		Refunded:      order.RefundedAmount().Amount,
+		OrderCurrency: order.Amount().Currency,
+	}
 }
 
 type cardPaymentOrder struct {
@@ -107,6 +353,12 @@ type cardPaymentOrder struct {
 	URL           string        `json:"url"`
 	PaymentStatus PaymentStatus `json:"status"`
 	Comment       string        `json:"comment"`
+	OrderAmount   int           `json:"amount"`
+	Refunded      int           `json:"refunded,omitempty"`
+	// RefundPending is amount of refund which is being processed
+	RefundPending int `json:"refund_pending,omitempty"`
+	// OrderCurrency is currency of all amounts of order
+	OrderCurrency money.Currency `json:"currency,omitempty"`
 }
 
 func (c cardPaymentOrder) ReservationID() string {
@@ -117,6 +369,14 @@ func (cp cardPaymentOrder) Status() PaymentStatus {
 	return cp.PaymentStatus
 }
 
+func (cp cardPaymentOrder) Amount() money.Money {
+	return money.New(cp.OrderAmount, cp.OrderCurrency)
+}
+
+func (cp cardPaymentOrder) RefundedAmount() money.Money {
+	return money.New(cp.Refunded, cp.OrderCurrency)
+}
+
 type cardOrderDetails struct {
 	CardID uint `json:"card_id"`
 }
@@ -133,6 +393,15 @@ func (cp *CardSource) unmarshalDetailsJSON(req []byte) (OrderDetails, error) {
 	return res, nil
 }
 
+func (cp *CardSource) unmarshalOrderJSON(reservationID string, msg []byte) (Order, error) {
+	res := cardPaymentOrder{}
+	if err := json.Unmarshal(msg, &res); err != nil {
+		return nil, err
+	}
+	res.RID = reservationID
+	return res, nil
+}
+
 func (cp *CardSource) Start(_ context.Context) error {
 	// assume that it will connect to some message queue to get updates
 	return nil
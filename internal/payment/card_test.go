@@ -0,0 +1,79 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
+)
+
+func TestCardSource_OrderState(t *testing.T) {
+	ctx := context.Background()
+	card := NewCardSource(&config.Config{Payment: config.Payment{Card: config.Card{WebhookSecret: "secret"}}})
+	p := NewPaymentProvider(NewCashSource(), card)
+	amount := money.New(100, "EUR")
+	details := cardOrderDetails{CardID: 1}
+
+	if _, err := p.AuthorizeOrder(ctx, "1", amount, "cash", nil, "cash"); !errors.Is(err, ErrCaptureNotSupported) {
+		t.Errorf("authorization of cash order error = %v, want %v", err, ErrCaptureNotSupported)
+	}
+
+	// status is the state of order kept by creation key after the operation
+	authorized := cardPaymentOrder{RID: "1", PaymentStatus: PaymentStatusAuthorized, OrderAmount: 100, OrderCurrency: "EUR"}
+	tests := []struct {
+		name    string
+		operate func(order Order) error
+		status  PaymentStatus
+	}{
+		{
+			name:    "voided",
+			operate: func(order Order) error { _, err := p.VoidOrder(ctx, "card", order); return err },
+			status:  PaymentStatusCanceled,
+		},
+		{
+			name:    "captured",
+			operate: func(order Order) error { _, err := p.CaptureOrder(ctx, "card", order, "capture"); return err },
+			status:  PaymentStatusCaptured,
+		},
+		{
+			name: "refunded by webhook",
+			operate: func(Order) error {
+				return card.applyWebhook(cardPaymentOrder{RID: "1", PaymentStatus: PaymentStatusRefunded, OrderAmount: 100, Refunded: 100, OrderCurrency: "EUR"})
+			},
+			status: PaymentStatusRefunded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.AuthorizeOrder(ctx, "1", amount, "card", details, tt.name); err != nil {
+				t.Fatal(err)
+			}
+			if err := card.applyWebhook(authorized); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.operate(authorized); err != nil {
+				t.Fatal(err)
+			}
+
+			retried, err := p.AuthorizeOrder(ctx, "1", amount, "card", details, tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if retried.Status() != tt.status {
+				t.Errorf("retried order status = %s, want %s", retried.Status(), tt.status)
+			}
+		})
+	}
+
+	// webhook about order does not change result of capture kept by its own key
+	captured, err := p.CaptureOrder(ctx, "card", authorized, "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if captured.Status() != PaymentStatusCaptured {
+		t.Errorf("retried capture status = %s, want %s", captured.Status(), PaymentStatusCaptured)
+	}
+}
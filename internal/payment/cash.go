@@ -1,5 +1,12 @@
 package payment
 
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/antnmxmv/booking-service/internal/money"
+)
+
 // CashSource is example of synchronious payment source
 type CashSource struct {
 }
@@ -19,16 +26,30 @@ func NewCashSource() *CashSource {
 	return &CashSource{}
 }
 
-// createOrder creates order in completed state 'success' state
-func (cp *CashSource) createOrder(reservationID string, amount int, _ OrderDetails) (Order, error) {
+// createOrder creates order in completed state 'success' state. it has no side effects, so idempotency key is not needed
+func (cp *CashSource) createOrder(_ context.Context, reservationID string, amount money.Money, _ OrderDetails, _ string) (Order, error) {
 	return &cashPaymentOrder{
 		PaymentStatus: PaymentStatusSuccess,
 		RID:           reservationID,
+		OrderAmount:   amount.Amount,
+		OrderCurrency: amount.Currency,
+	}, nil
+}
+
+// refundOrder returns cash immediately
+func (cp *CashSource) refundOrder(_ context.Context, order Order, amount money.Money, _ string) (Order, error) {
+	refunded := order.RefundedAmount().Amount + amount.Amount
+	return &cashPaymentOrder{
+		PaymentStatus: refundedStatus(order.Amount().Amount, refunded),
+		RID:           order.ReservationID(),
+		OrderAmount:   order.Amount().Amount,
+		Refunded:      refunded,
+		OrderCurrency: order.Amount().Currency,
 	}, nil
 }
 
 // cash payment order can be canceled even if succeeded
-func (cp *CashSource) cancelOrder(reservationID string) (Order, error) {
+func (cp *CashSource) cancelOrder(_ context.Context, reservationID string) (Order, error) {
 	return &cardPaymentOrder{
 		PaymentStatus: PaymentStatusCanceled,
 		RID:           reservationID,
@@ -39,9 +60,22 @@ func (cp *CashSource) unmarshalDetailsJSON([]byte) (OrderDetails, error) {
 	return nil, nil
 }
 
+func (cp *CashSource) unmarshalOrderJSON(reservationID string, msg []byte) (Order, error) {
+	res := &cashPaymentOrder{}
+	if err := json.Unmarshal(msg, res); err != nil {
+		return nil, err
+	}
+	res.RID = reservationID
+	return res, nil
+}
+
 type cashPaymentOrder struct {
 	PaymentStatus PaymentStatus `json:"status"`
 	RID           string        `json:"-"`
+	OrderAmount   int           `json:"amount"`
+	Refunded      int           `json:"refunded,omitempty"`
+	// OrderCurrency is currency of all amounts of order
+	OrderCurrency money.Currency `json:"currency,omitempty"`
 }
 
 func (cp *cashPaymentOrder) ReservationID() string {
@@ -51,3 +85,11 @@ func (cp *cashPaymentOrder) ReservationID() string {
 func (cp *cashPaymentOrder) Status() PaymentStatus {
 	return cp.PaymentStatus
 }
+
+func (cp *cashPaymentOrder) Amount() money.Money {
+	return money.New(cp.OrderAmount, cp.OrderCurrency)
+}
+
+func (cp *cashPaymentOrder) RefundedAmount() money.Money {
+	return money.New(cp.Refunded, cp.OrderCurrency)
+}
@@ -3,7 +3,10 @@ package payment
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
+
+	"github.com/antnmxmv/booking-service/internal/money"
 )
 
 // Provider is payment source decorators factory.
@@ -56,17 +59,46 @@ func (p *Provider) UnmarshalDetailsJSON(pType SourceType, msg []byte) (OrderDeta
 	return nil, errors.New("payment provider not supported")
 }
 
-// CreateOrder creates payment order using reservationID as identifier
-func (p *Provider) CreateOrder(reservationID string, amount int, sourceType SourceType, details OrderDetails) (Order, error) {
-	order, err := p.sources[sourceType].createOrder(reservationID, amount, details)
+// UnmarshalOrderJSON restores order of given payment type from its json representation
+func (p *Provider) UnmarshalOrderJSON(pType SourceType, reservationID string, msg []byte) (Order, error) {
+	if provider, ok := p.sources[pType]; ok {
+		return provider.unmarshalOrderJSON(reservationID, msg)
+	}
+	return nil, errors.New("payment provider not supported")
+}
+
+// ErrUnavailable is returned when payment source does not answer in time, operation may succeed later
+var ErrUnavailable = errors.New("payment source is unavailable")
+
+// sourceError marks timed out or interrupted request to payment source as ErrUnavailable
+func sourceError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%w: %s", ErrUnavailable, err.Error())
+	}
+	return err
+}
+
+// CreateOrder creates payment order using reservationID as identifier.
+// retry with the same idempotency key returns already created order
+func (p *Provider) CreateOrder(ctx context.Context, reservationID string, amount money.Money, sourceType SourceType, details OrderDetails, idempotencyKey string) (Order, error) {
+	source, ok := p.sources[sourceType]
+	if !ok {
+		return nil, errors.New("payment provider not supported")
+	}
+	order, err := source.createOrder(ctx, reservationID, amount, details, idempotencyKey)
 	if err != nil {
-		return nil, err
+		return nil, sourceError(err)
 	}
 	return order, nil
 }
 
-func (p *Provider) CancelOrder(reservationID string, sourceType SourceType) (Order, error) {
-	return p.sources[sourceType].cancelOrder(reservationID)
+func (p *Provider) CancelOrder(ctx context.Context, reservationID string, sourceType SourceType) (Order, error) {
+	source, ok := p.sources[sourceType]
+	if !ok {
+		return nil, errors.New("payment provider not supported")
+	}
+	order, err := source.cancelOrder(ctx, reservationID)
+	return order, sourceError(err)
 }
 
 func (p *Provider) SubscribeOnStatusUpdates() <-chan Order {
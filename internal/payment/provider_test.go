@@ -0,0 +1,28 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
+)
+
+func TestProvider_SourceErrors(t *testing.T) {
+	p := NewPaymentProvider(NewCashSource(), NewCardSource(&config.Config{}))
+
+	if _, err := p.CreateOrder(context.Background(), "1", money.New(100, "EUR"), "wrong", nil, "1"); err == nil {
+		t.Error("order of unknown source type is created")
+	}
+	if _, err := p.CancelOrder(context.Background(), "1", "wrong"); err == nil {
+		t.Error("order of unknown source type is canceled")
+	}
+
+	if err := sourceError(context.DeadlineExceeded); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("timed out source error = %v, want %v", err, ErrUnavailable)
+	}
+	if err := sourceError(ErrNotAuthorized); errors.Is(err, ErrUnavailable) {
+		t.Errorf("source error %v is marked as %v", err, ErrUnavailable)
+	}
+}
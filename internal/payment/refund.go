@@ -0,0 +1,66 @@
+package payment
+
+import (
+	"context"
+	"errors"
+
+	"github.com/antnmxmv/booking-service/internal/money"
+)
+
+const (
+	// PaymentStatusRefundPending is status of paid order while refund is being processed
+	PaymentStatusRefundPending     PaymentStatus = "refund_pending"
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
+	PaymentStatusRefunded          PaymentStatus = "refunded"
+)
+
+var (
+	ErrNotRefundable       = errors.New("payment order is not paid or refund is in progress")
+	ErrInvalidRefundAmount = errors.New("refund amount must be positive and not exceed paid amount")
+)
+
+// IsRefundable checks if money of order in this status can be returned
+func IsRefundable(status PaymentStatus) bool {
+	return status == PaymentStatusSuccess || status == PaymentStatusCaptured || status == PaymentStatusPartiallyRefunded
+}
+
+// IsRefundStatus checks if status is a state of refund, not of payment
+func IsRefundStatus(status PaymentStatus) bool {
+	return status == PaymentStatusRefundPending || status == PaymentStatusPartiallyRefunded || status == PaymentStatusRefunded
+}
+
+// RefundableAmount is paid amount which is not refunded yet
+func RefundableAmount(order Order) money.Money {
+	// refunds are in currency of order
+	return money.New(order.Amount().Amount-order.RefundedAmount().Amount, order.Amount().Currency)
+}
+
+// refundedStatus is status of order after refund is completed
+func refundedStatus(amount, refunded int) PaymentStatus {
+	if refunded >= amount {
+		return PaymentStatusRefunded
+	}
+	return PaymentStatusPartiallyRefunded
+}
+
+// RefundOrder returns given amount of paid order. asynchronious source returns order
+// in 'refund_pending' status and sends completed refund to status updates subscribers.
+// retry with the same idempotency key returns already created refund. amount must be in currency of order
+func (p *Provider) RefundOrder(ctx context.Context, sourceType SourceType, order Order, amount money.Money, idempotencyKey string) (Order, error) {
+	source, ok := p.sources[sourceType]
+	if !ok {
+		return nil, errors.New("payment provider not supported")
+	}
+	if order == nil || !IsRefundable(order.Status()) {
+		return nil, ErrNotRefundable
+	}
+	refundable := RefundableAmount(order)
+	if amount.Currency != refundable.Currency {
+		return nil, money.ErrCurrencyMismatch
+	}
+	if amount.Amount <= 0 || amount.Amount > refundable.Amount {
+		return nil, ErrInvalidRefundAmount
+	}
+	refund, err := source.refundOrder(ctx, order, amount, idempotencyKey)
+	return refund, sourceError(err)
+}
@@ -0,0 +1,61 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
+)
+
+func TestProvider_RefundOrder(t *testing.T) {
+	ctx := context.Background()
+	card := NewCardSource(&config.Config{Payment: config.Payment{Card: config.Card{Timeout: time.Millisecond}}})
+	p := NewPaymentProvider(NewCashSource(), card)
+
+	paid := cardPaymentOrder{RID: "1", PaymentStatus: PaymentStatusSuccess, OrderAmount: 100, OrderCurrency: "EUR"}
+
+	if _, err := p.RefundOrder(ctx, "card", paid, money.New(101, "EUR"), ""); !errors.Is(err, ErrInvalidRefundAmount) {
+		t.Errorf("refund over paid amount error = %v, want %v", err, ErrInvalidRefundAmount)
+	}
+	if _, err := p.RefundOrder(ctx, "card", paid, money.New(10, "USD"), ""); !errors.Is(err, money.ErrCurrencyMismatch) {
+		t.Errorf("refund in other currency error = %v, want %v", err, money.ErrCurrencyMismatch)
+	}
+	if _, err := p.RefundOrder(ctx, "card", cardPaymentOrder{RID: "1", PaymentStatus: PaymentStatusPending, OrderAmount: 100, OrderCurrency: "EUR"}, money.New(10, "EUR"), ""); !errors.Is(err, ErrNotRefundable) {
+		t.Errorf("refund of pending order error = %v, want %v", err, ErrNotRefundable)
+	}
+
+	// partial refund is completed asynchroniously
+	pending, err := p.RefundOrder(ctx, "card", paid, money.New(30, "EUR"), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Status() != PaymentStatusRefundPending {
+		t.Errorf("refund status = %s, want %s", pending.Status(), PaymentStatusRefundPending)
+	}
+	if retry, err := p.RefundOrder(ctx, "card", paid, money.New(30, "EUR"), "key"); err != nil || retry.Status() != PaymentStatusRefundPending {
+		t.Errorf("refund retry = %v, %v, want the same pending refund", retry, err)
+	}
+
+	var partial Order
+	select {
+	case partial = <-p.SubscribeOnStatusUpdates():
+	case <-time.After(time.Second * 5):
+		t.Fatal("refund update is not received")
+	}
+	if partial.Status() != PaymentStatusPartiallyRefunded || partial.RefundedAmount() != money.New(30, "EUR") {
+		t.Errorf("refund update = %s %s, want %s 30 EUR", partial.Status(), partial.RefundedAmount(), PaymentStatusPartiallyRefunded)
+	}
+
+	// the rest of cash is returned immediately
+	cash := &cashPaymentOrder{RID: "2", PaymentStatus: PaymentStatusPartiallyRefunded, OrderAmount: 100, Refunded: 30, OrderCurrency: "EUR"}
+	full, err := p.RefundOrder(ctx, "cash", cash, RefundableAmount(cash), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Status() != PaymentStatusRefunded || full.RefundedAmount() != money.New(100, "EUR") {
+		t.Errorf("full refund = %s %s, want %s 100 EUR", full.Status(), full.RefundedAmount(), PaymentStatusRefunded)
+	}
+}
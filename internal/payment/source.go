@@ -1,5 +1,11 @@
 package payment
 
+import (
+	"context"
+
+	"github.com/antnmxmv/booking-service/internal/money"
+)
+
 type PaymentStatus string
 
 const (
@@ -7,11 +13,19 @@ const (
 	PaymentStatusSuccess  PaymentStatus = "success"
 	PaymentStatusCanceled PaymentStatus = "canceled"
 	PaymentStatusFailed   PaymentStatus = "failed"
+	// PaymentStatusAuthorized is status of order which amount is held on card until capture
+	PaymentStatusAuthorized PaymentStatus = "authorized"
+	// PaymentStatusCaptured is status of authorized order which amount is charged
+	PaymentStatusCaptured PaymentStatus = "captured"
 )
 
 type Order interface {
 	ReservationID() string
 	Status() PaymentStatus
+	// Amount is paid amount
+	Amount() money.Money
+	// RefundedAmount is amount of completed refunds, it is in currency of paid amount
+	RefundedAmount() money.Money
 }
 
 type SourceType string
@@ -26,12 +40,19 @@ type Source interface {
 	name() SourceType
 	// createOrder creates an order
 	// if order is not in completed state ('finished' or 'created') after creation,
-	// observer would continiously check it's status
-	createOrder(reservationID string, amount int, details OrderDetails) (Order, error)
+	// observer would continiously check it's status.
+	// order created with the same non-empty idempotency key is returned instead of creating new one
+	createOrder(ctx context.Context, reservationID string, amount money.Money, details OrderDetails, idempotencyKey string) (Order, error)
 
-	cancelOrder(reservationID string) (Order, error)
+	cancelOrder(ctx context.Context, reservationID string) (Order, error)
+
+	// refundOrder returns amount of paid order, amount is already checked by provider
+	refundOrder(ctx context.Context, order Order, amount money.Money, idempotencyKey string) (Order, error)
 
 	unmarshalDetailsJSON([]byte) (OrderDetails, error)
 
+	// unmarshalOrderJSON restores order persisted by repository
+	unmarshalOrderJSON(reservationID string, msg []byte) (Order, error)
+
 	subscribe() <-chan Order
 }
@@ -0,0 +1,71 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// SignatureHeader carries hex encoded HMAC-SHA256 of timestamp and webhook body made with secret shared with acquirer
+	SignatureHeader = "X-Signature"
+	// TimestampHeader is unix time of webhook in seconds, it is signed with body so old webhook can not be replayed
+	TimestampHeader = "X-Signature-Timestamp"
+	// WebhookTolerance is max difference between webhook timestamp and current time
+	WebhookTolerance = time.Minute * 5
+)
+
+var (
+	ErrWebhookNotSupported = errors.New("payment source does not accept webhooks")
+	ErrInvalidSignature    = errors.New("webhook signature is invalid")
+	ErrInvalidWebhook      = errors.New("webhook payload is invalid")
+)
+
+// webhookSource is asynchronious source which gets order status updates from acquirer callbacks
+type webhookSource interface {
+	// webhookSecret is key of payload signature, webhooks are disabled when it is empty
+	webhookSecret() string
+	// handleWebhook parses source specific payload and sends order to subscribers
+	handleWebhook(ctx context.Context, payload []byte) (Order, error)
+}
+
+// Sign makes signature of webhook payload sent at timestamp
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HandleWebhook verifies signature and timestamp of acquirer callback and passes updated order to status updates subscribers
+func (p *Provider) HandleWebhook(ctx context.Context, sourceType SourceType, payload []byte, timestamp, signature string) (Order, error) {
+	source, ok := p.sources[sourceType].(webhookSource)
+	if !ok || source.webhookSecret() == "" {
+		return nil, ErrWebhookNotSupported
+	}
+
+	expected, err := hex.DecodeString(Sign(source.webhookSecret(), timestamp, payload))
+	if err != nil {
+		return nil, err
+	}
+	actual, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, actual) {
+		return nil, ErrInvalidSignature
+	}
+
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(sentAt, 0)); age > WebhookTolerance || age < -WebhookTolerance {
+		return nil, fmt.Errorf("%w: timestamp is out of tolerance", ErrInvalidSignature)
+	}
+
+	return source.handleWebhook(ctx, payload)
+}
@@ -1,25 +1,38 @@
 package price
 
 import (
+	"context"
 	"sync"
 
 	"github.com/antnmxmv/booking-service/internal/booking"
 	"github.com/antnmxmv/booking-service/internal/booking/jobs"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
 )
 
 // ExamplePriceService very simple example of discount service logic.
 // all price generation logic should be in another service where managers
 // will be able to create  temporary discounts of many types and conditions
 type ExamplePriceService struct {
-	userOrdersCount map[string]int
+	cnf *config.Config
+	// userFirstOrders are ids of the first reservation of user, so retried price job
+	// of the same reservation gets the same discount
+	userFirstOrders map[string]string
 	mux             sync.Mutex
 }
 
-func NewExampleProvider() jobs.PriceServiceFacade {
-	return &ExamplePriceService{userOrdersCount: map[string]int{}}
+func NewExampleProvider(cnf *config.Config) jobs.PriceServiceFacade {
+	return &ExamplePriceService{cnf: cnf, userFirstOrders: map[string]string{}}
 }
 
-func (p *ExamplePriceService) GetPrice(reservation booking.Reservation) (discounts []string, finalCost int, err error) {
+// GetPrice calculates cost in minor units of base currency of hotel
+func (p *ExamplePriceService) GetPrice(ctx context.Context, reservation booking.Reservation) (discounts []string, cost money.Money, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, money.Money{}, err
+	}
+
+	finalCost := 0
+
 	discounts = []string{}
 	totalRoomsCount := uint(0)
 	// base cost
@@ -41,9 +54,13 @@ func (p *ExamplePriceService) GetPrice(reservation booking.Reservation) (discoun
 	// first order discount example (user wide, hotel wide, etc)
 
 	p.mux.Lock()
-	isFirstOrder := p.userOrdersCount[reservation.UserID] == 0
 	// TODO profile service facade mock to get completed orders by user id
-	p.userOrdersCount[reservation.UserID] += 1
+	firstOrderID, ok := p.userFirstOrders[reservation.UserID]
+	if !ok {
+		firstOrderID = reservation.ID
+		p.userFirstOrders[reservation.UserID] = firstOrderID
+	}
+	isFirstOrder := firstOrderID == reservation.ID
 	p.mux.Unlock()
 
 	if isFirstOrder {
@@ -51,5 +68,5 @@ func (p *ExamplePriceService) GetPrice(reservation booking.Reservation) (discoun
 		discounts = append(discounts, "first_order")
 	}
 
-	return discounts, finalCost, nil
+	return discounts, money.New(finalCost, money.Currency(p.cnf.Currency.HotelCurrency(reservation.HotelID))), nil
 }
@@ -0,0 +1,37 @@
+package price
+
+import (
+	"context"
+	"testing"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
+)
+
+func TestExamplePriceService_GetPriceRetry(t *testing.T) {
+	p := NewExampleProvider(&config.Config{Currency: config.Currency{Default: "EUR"}})
+
+	tests := []struct {
+		name          string
+		reservationID string
+		wantCost      money.Money
+	}{
+		{name: "first order", reservationID: "1", wantCost: money.New(475, "EUR")},
+		// e.g. price job is retried after transient error
+		{name: "retry of first order", reservationID: "1", wantCost: money.New(475, "EUR")},
+		{name: "second order", reservationID: "2", wantCost: money.New(500, "EUR")},
+		{name: "retry of second order", reservationID: "2", wantCost: money.New(500, "EUR")},
+	}
+
+	for _, tt := range tests {
+		r := booking.Reservation{ID: tt.reservationID, UserID: "user", RoomTypes: booking.RoomsRequest{{RoomType: "eco", Count: 1}}}
+		discounts, cost, err := p.GetPrice(context.Background(), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cost != tt.wantCost {
+			t.Errorf("%s: GetPrice() = %v %s, want %s", tt.name, discounts, cost, tt.wantCost)
+		}
+	}
+}
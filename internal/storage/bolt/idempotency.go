@@ -0,0 +1,90 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/idempotency"
+	"go.etcd.io/bbolt"
+)
+
+func (s *Storage) CreateIdempotencyKey(ctx context.Context, record *idempotency.Record, expiration idempotency.Expiration) (*idempotency.Record, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored *idempotency.Record
+
+	err = s.update(ctx, func(tx *bbolt.Tx) error {
+		keys := tx.Bucket(idempotencyBucket)
+		key := indexKey(record.UserID, record.Key)
+
+		if v := keys.Get(key); v != nil {
+			existing := &idempotency.Record{}
+			if err := json.Unmarshal(v, existing); err != nil {
+				return err
+			}
+			if !expiration.IsExpired(existing, record.CreatedAt) {
+				stored = existing
+				return idempotency.ErrKeyExists
+			}
+		}
+
+		return keys.Put(key, data)
+	})
+	if stored != nil {
+		return stored, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (s *Storage) CompleteIdempotencyKey(ctx context.Context, record *idempotency.Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.update(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(idempotencyBucket).Put(indexKey(record.UserID, record.Key), data)
+	})
+}
+
+func (s *Storage) DeleteIdempotencyKey(ctx context.Context, userID, key string) error {
+	return s.update(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(idempotencyBucket).Delete(indexKey(userID, key))
+	})
+}
+
+func (s *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiration idempotency.Expiration, now time.Time) error {
+	return s.update(ctx, func(tx *bbolt.Tx) error {
+		keys := tx.Bucket(idempotencyBucket)
+
+		var expired [][]byte
+		err := keys.ForEach(func(k, v []byte) error {
+			record := &idempotency.Record{}
+			if err := json.Unmarshal(v, record); err != nil {
+				return err
+			}
+			if expiration.IsExpired(record, now) {
+				// keys can not be deleted while iterating over bucket
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := keys.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
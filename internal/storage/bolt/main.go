@@ -0,0 +1,512 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/storage"
+	"github.com/antnmxmv/booking-service/internal/storage/inmemory"
+	"go.etcd.io/bbolt"
+)
+
+const dateLayout = "2006-01-02"
+
+// separator splits parts of composite keys. it is less than any printable symbol,
+// so keys with the same prefix are placed together and sorted by next part
+const separator = 0
+
+var (
+	// reservationsBucket is reservation id -> reservation json document
+	reservationsBucket = []byte("reservations")
+	// statusIndexBucket is status + separator + reservation id -> nothing
+	statusIndexBucket = []byte("reservations_by_status")
+	// userIndexBucket is user id + separator + reservation id -> nothing
+	userIndexBucket = []byte("reservations_by_user")
+	// quotasBucket is hotel id + separator + date + separator + room type -> free rooms count
+	quotasBucket = []byte("room_availability")
+	// sagaLogBucket is reservation id + separator + big-endian sequence -> saga log entry json
+	sagaLogBucket = []byte("saga_log")
+	// idempotencyBucket is user id + separator + idempotency key -> record json
+	idempotencyBucket = []byte("idempotency_keys")
+	// outboxBucket is outbox message id -> message json
+	outboxBucket = []byte("outbox")
+)
+
+// Storage is booking.Repository kept in a single file, so one instance of
+// service survives restarts without any database server.
+// bolt allows only one writing transaction at a time, so quotas can not be overbooked
+type Storage struct {
+	cnf              *config.Config
+	codec            *storage.ReservationCodec
+	roomAvailability []*inmemory.RoomAvailability
+	db               *bbolt.DB
+}
+
+func NewStorage(cnf *config.Config, paymentCodec storage.PaymentCodec) *Storage {
+	return &Storage{
+		cnf:   cnf,
+		codec: storage.NewReservationCodec(paymentCodec),
+	}
+}
+
+// WithRoomAvailability adds initial quotas. they are written on start only if
+// there is no quota for the same hotel, date and room type in file
+func (s *Storage) WithRoomAvailability(roomAvailability []*inmemory.RoomAvailability) *Storage {
+	s.roomAvailability = roomAvailability
+	return s
+}
+
+func (s *Storage) Start(_ context.Context) error {
+	db, err := bbolt.Open(s.cnf.Storage.Bolt.Path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{reservationsBucket, statusIndexBucket, userIndexBucket, quotasBucket, sagaLogBucket, idempotencyBucket, outboxBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		quotas := tx.Bucket(quotasBucket)
+		for _, r := range s.roomAvailability {
+			key := quotaKey(r.HotelID, r.Date, r.RoomType)
+			if quotas.Get(key) == nil {
+				if err := quotas.Put(key, encodeQuota(r.Quota)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	s.db = db
+	return nil
+}
+
+func (s *Storage) Stop(_ context.Context) error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func (s *Storage) CreateReservation(ctx context.Context, reservation *booking.ReservationRequest) (*booking.Reservation, error) {
+	result := &booking.Reservation{
+		ID:                    reservation.ID,
+		UserID:                reservation.UserID,
+		HotelID:               reservation.HotelID,
+		RoomTypes:             reservation.RoomsRequest,
+		StartDate:             reservation.StartDate,
+		PaymentType:           reservation.PaymentType,
+		PaymentRequestDetails: reservation.PaymentDetails,
+		EndDate:               reservation.EndDate,
+		DisplayCurrency:       reservation.DisplayCurrency,
+		Status:                booking.CreatedReservationStatus,
+		LastUpdateTime:        time.Now(),
+		Version:               1,
+	}
+
+	err := s.update(ctx, func(tx *bbolt.Tx) error {
+		if tx.Bucket(reservationsBucket).Get([]byte(result.ID)) != nil {
+			return booking.ErrDuplicate
+		}
+
+		needRoomTypes := roomTypesCount(reservation.RoomsRequest)
+
+		quotas := tx.Bucket(quotasBucket)
+		keys := [][]byte{}
+
+		err := forEachQuota(quotas, reservation.HotelID, reservation.StartDate, reservation.EndDate, func(key []byte, date time.Time, roomType string, quota uint) error {
+			need, ok := needRoomTypes[roomType]
+			if !ok {
+				return nil
+			}
+			if need > quota {
+				return booking.WrapError(booking.ErrAlreadyBooked, date.String())
+			}
+			// keys are valid only until bucket is modified
+			keys = append(keys, append([]byte{}, key...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		daysToBook := int(reservation.EndDate.Sub(reservation.StartDate).Hours()/24) + 1
+		if len(keys) < len(needRoomTypes)*daysToBook {
+			return booking.ErrNotWorkingDays
+		}
+
+		for _, key := range keys {
+			_, _, roomType := parseQuotaKey(key)
+			quota := decodeQuota(quotas.Get(key)) - needRoomTypes[roomType]
+			if err := quotas.Put(key, encodeQuota(quota)); err != nil {
+				return err
+			}
+		}
+
+		return s.putReservation(tx, nil, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *Storage) CancelReservation(ctx context.Context, id string) error {
+	return s.update(ctx, func(tx *bbolt.Tx) error {
+		reservation, err := s.getReservation(tx, id)
+		if err != nil {
+			return err
+		}
+
+		// quota must be released only once
+		if reservation.Status == booking.CanceledReservationStatus {
+			return nil
+		}
+
+		prev := *reservation
+		reservation.Status = booking.CanceledReservationStatus
+		reservation.Version++
+
+		roomTypeCount := roomTypesCount(reservation.RoomTypes)
+		quotas := tx.Bucket(quotasBucket)
+		released := map[string]uint{}
+
+		// bucket can not be modified while cursor walks over it
+		err = forEachQuota(quotas, reservation.HotelID, reservation.StartDate, reservation.EndDate, func(key []byte, _ time.Time, roomType string, quota uint) error {
+			if count, ok := roomTypeCount[roomType]; ok {
+				released[string(key)] = quota + count
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for key, quota := range released {
+			if err := quotas.Put([]byte(key), encodeQuota(quota)); err != nil {
+				return err
+			}
+		}
+
+		return s.putReservation(tx, &prev, reservation)
+	})
+}
+
+func (s *Storage) UpdateReservation(ctx context.Context, update *booking.Reservation) error {
+	return s.UpdateReservationWithOutbox(ctx, update, nil, nil)
+}
+
+func (s *Storage) UpdateReservationWithOutbox(ctx context.Context, update *booking.Reservation, add []*booking.OutboxMessage, remove []string) error {
+	return s.update(ctx, func(tx *bbolt.Tx) error {
+		r, err := s.getReservation(tx, update.ID)
+		if err != nil {
+			return err
+		}
+
+		for i, rt := range update.RoomTypes {
+			if i >= len(r.RoomTypes) || r.RoomTypes[i] != rt {
+				return errors.New("room types can not be updated. please create new reservation")
+			}
+		}
+
+		if r.Version != update.Version {
+			return booking.ErrConcurrentModification
+		}
+
+		next := *update
+		next.Version++
+
+		if err := s.putReservation(tx, r, &next); err != nil {
+			return err
+		}
+
+		outbox := tx.Bucket(outboxBucket)
+		for _, m := range add {
+			data, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			if err := outbox.Put([]byte(m.ID), data); err != nil {
+				return err
+			}
+		}
+		for _, id := range remove {
+			if err := outbox.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+
+		update.Version = next.Version
+		return nil
+	})
+}
+
+func (s *Storage) GetOutboxMessages(ctx context.Context) ([]*booking.OutboxMessage, error) {
+	res := []*booking.OutboxMessage{}
+
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(_, v []byte) error {
+			m := &booking.OutboxMessage{}
+			if err := json.Unmarshal(v, m); err != nil {
+				return err
+			}
+			res = append(res, m)
+			return nil
+		})
+	})
+
+	// messages are keyed by id, so they are put in order of adding here
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+
+	return res, err
+}
+
+func (s *Storage) GetRoomsByDates(ctx context.Context, hotelID string, startDate, endDate time.Time) ([]*booking.RoomAvailability, error) {
+	res := make([]*booking.RoomAvailability, 0, int(endDate.Sub(startDate).Hours())/24)
+
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		return forEachQuota(tx.Bucket(quotasBucket), hotelID, startDate, endDate, func(_ []byte, date time.Time, roomType string, quota uint) error {
+			if quota > 0 {
+				res = append(res, &booking.RoomAvailability{
+					Date:      date,
+					Type:      roomType,
+					FreeCount: quota,
+				})
+			}
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+// GetNotFinishedReservations walks status index skipping finished and canceled groups entirely
+func (s *Storage) GetNotFinishedReservations(ctx context.Context) ([]*booking.Reservation, error) {
+	res := []*booking.Reservation{}
+
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		reservations := tx.Bucket(reservationsBucket)
+		c := tx.Bucket(statusIndexBucket).Cursor()
+
+		for k, _ := c.First(); k != nil; {
+			status, id := splitIndexKey(k)
+
+			if status == string(booking.FinishedReservationStatus) || status == string(booking.CanceledReservationStatus) {
+				// seek to the first key after all keys of this status
+				k, _ = c.Seek(append([]byte(status), separator+1))
+				continue
+			}
+
+			r, err := s.codec.Unmarshal(reservations.Get([]byte(id)))
+			if err != nil {
+				return err
+			}
+			res = append(res, r)
+
+			k, _ = c.Next()
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+func (s *Storage) GetReservationByID(ctx context.Context, id string) (*booking.Reservation, error) {
+	var res *booking.Reservation
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		var err error
+		res, err = s.getReservation(tx, id)
+		return err
+	})
+	return res, err
+}
+
+func (s *Storage) GetReservationsByUserID(ctx context.Context, userID string) ([]*booking.Reservation, error) {
+	res := []*booking.Reservation{}
+
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		reservations := tx.Bucket(reservationsBucket)
+		prefix := indexKey(userID, "")
+		c := tx.Bucket(userIndexBucket).Cursor()
+
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			_, id := splitIndexKey(k)
+			r, err := s.codec.Unmarshal(reservations.Get([]byte(id)))
+			if err != nil {
+				return err
+			}
+			res = append(res, r)
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+func (s *Storage) AppendSagaLog(ctx context.Context, entry *booking.SagaLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return s.update(ctx, func(tx *bbolt.Tx) error {
+		sagaLog := tx.Bucket(sagaLogBucket)
+		seq, err := sagaLog.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := binary.BigEndian.AppendUint64(append([]byte(entry.ReservationID), separator), seq)
+		return sagaLog.Put(key, data)
+	})
+}
+
+func (s *Storage) GetSagaLog(ctx context.Context, reservationID string) ([]*booking.SagaLogEntry, error) {
+	res := []*booking.SagaLogEntry{}
+
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		prefix := append([]byte(reservationID), separator)
+		c := tx.Bucket(sagaLogBucket).Cursor()
+
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			entry := &booking.SagaLogEntry{}
+			if err := json.Unmarshal(v, entry); err != nil {
+				return err
+			}
+			res = append(res, entry)
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+// update runs fn in writable transaction. bolt transactions can not be interrupted,
+// so transaction is rolled back if context is canceled before it is committed
+func (s *Storage) update(ctx context.Context, fn func(tx *bbolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return ctx.Err()
+	})
+}
+
+func (s *Storage) view(ctx context.Context, fn func(tx *bbolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(fn)
+}
+
+func (s *Storage) getReservation(tx *bbolt.Tx, id string) (*booking.Reservation, error) {
+	data := tx.Bucket(reservationsBucket).Get([]byte(id))
+	if data == nil {
+		return nil, booking.ErrNotFound
+	}
+	return s.codec.Unmarshal(data)
+}
+
+// putReservation saves reservation and moves its index entries from previous version
+func (s *Storage) putReservation(tx *bbolt.Tx, prev, r *booking.Reservation) error {
+	data, err := s.codec.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(reservationsBucket).Put([]byte(r.ID), data); err != nil {
+		return err
+	}
+
+	statusIndex := tx.Bucket(statusIndexBucket)
+	userIndex := tx.Bucket(userIndexBucket)
+
+	if prev != nil {
+		if err := statusIndex.Delete(indexKey(string(prev.Status), prev.ID)); err != nil {
+			return err
+		}
+		if err := userIndex.Delete(indexKey(prev.UserID, prev.ID)); err != nil {
+			return err
+		}
+	}
+
+	if err := statusIndex.Put(indexKey(string(r.Status), r.ID), []byte{}); err != nil {
+		return err
+	}
+	return userIndex.Put(indexKey(r.UserID, r.ID), []byte{})
+}
+
+// forEachQuota calls fn for every quota of hotel in dates range ordered by date
+func forEachQuota(quotas *bbolt.Bucket, hotelID string, startDate, endDate time.Time, fn func(key []byte, date time.Time, roomType string, quota uint) error) error {
+	c := quotas.Cursor()
+	last := []byte(endDate.UTC().Format(dateLayout))
+	hotelPrefix := append([]byte(hotelID), separator)
+
+	for k, v := c.Seek(quotaKey(hotelID, startDate, "")); k != nil && bytes.HasPrefix(k, hotelPrefix); k, v = c.Next() {
+		_, date, roomType := parseQuotaKey(k)
+		if bytes.Compare([]byte(date.Format(dateLayout)), last) > 0 {
+			break
+		}
+		if err := fn(k, date, roomType, decodeQuota(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func quotaKey(hotelID string, date time.Time, roomType string) []byte {
+	key := append([]byte(hotelID), separator)
+	key = append(key, date.UTC().Format(dateLayout)...)
+	key = append(key, separator)
+	return append(key, roomType...)
+}
+
+func parseQuotaKey(key []byte) (hotelID string, date time.Time, roomType string) {
+	parts := bytes.SplitN(key, []byte{separator}, 3)
+	date, _ = time.Parse(dateLayout, string(parts[1]))
+	return string(parts[0]), date, string(parts[2])
+}
+
+func encodeQuota(quota uint) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(quota))
+}
+
+func decodeQuota(v []byte) uint {
+	return uint(binary.BigEndian.Uint64(v))
+}
+
+func indexKey(value, id string) []byte {
+	return append(append([]byte(value), separator), id...)
+}
+
+func splitIndexKey(key []byte) (value, id string) {
+	i := bytes.IndexByte(key, separator)
+	return string(key[:i]), string(key[i+1:])
+}
+
+func roomTypesCount(rooms booking.RoomsRequest) map[string]uint {
+	res := make(map[string]uint, len(rooms))
+	for _, r := range rooms {
+		res[r.RoomType] += r.Count
+	}
+	return res
+}
@@ -0,0 +1,251 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/idempotency"
+	"github.com/antnmxmv/booking-service/internal/payment"
+	"github.com/antnmxmv/booking-service/internal/storage/inmemory"
+)
+
+const testHotelID = "hotel"
+
+var testDate = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestStorage(t *testing.T, path string) *Storage {
+	cnf := &config.Config{Storage: config.Storage{Bolt: config.Bolt{Path: path}}}
+
+	s := NewStorage(cnf, payment.NewPaymentProvider(payment.NewCashSource())).WithRoomAvailability([]*inmemory.RoomAvailability{
+		{HotelID: testHotelID, RoomType: "eco", Date: testDate, Quota: 2},
+		{HotelID: testHotelID, RoomType: "lux", Date: testDate, Quota: 1},
+		{HotelID: "other", RoomType: "eco", Date: testDate, Quota: 5},
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newRequest(id string, date time.Time, rooms uint) *booking.ReservationRequest {
+	return &booking.ReservationRequest{
+		ID:           id,
+		UserID:       "user",
+		HotelID:      testHotelID,
+		RoomsRequest: booking.RoomsRequest{{RoomType: "eco", Count: rooms}},
+		PaymentType:  "cash",
+		StartDate:    date,
+		EndDate:      date,
+	}
+}
+
+func freeRooms(t *testing.T, s *Storage, roomType string) uint {
+	rooms, err := s.GetRoomsByDates(context.Background(), testHotelID, testDate, testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rooms {
+		if r.Type == roomType {
+			return r.FreeCount
+		}
+	}
+	return 0
+}
+
+func TestStorage_CreateReservation(t *testing.T) {
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "booking.db"))
+	defer s.Stop(context.Background())
+
+	tests := []struct {
+		name    string
+		req     *booking.ReservationRequest
+		wantErr error
+	}{
+		{name: "all good", req: newRequest("1", testDate, 1)},
+		{name: "duplicate", req: newRequest("1", testDate, 1), wantErr: booking.ErrDuplicate},
+		{name: "already booked", req: newRequest("2", testDate, 2), wantErr: booking.ErrAlreadyBooked},
+		{name: "not working days", req: newRequest("3", testDate.Add(time.Hour*24), 1), wantErr: booking.ErrNotWorkingDays},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.CreateReservation(context.Background(), tt.req); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CreateReservation() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if got := freeRooms(t, s, "eco"); got != 1 {
+		t.Errorf("free eco rooms = %d, want 1", got)
+	}
+	if got := freeRooms(t, s, "lux"); got != 1 {
+		t.Errorf("free lux rooms = %d, want 1", got)
+	}
+}
+
+func TestStorage_Restart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "booking.db")
+
+	s := newTestStorage(t, path)
+	for _, id := range []string{"1", "2"} {
+		if _, err := s.CreateReservation(context.Background(), newRequest(id, testDate, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.CancelReservation(context.Background(), "1"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.GetReservationByID(context.Background(), "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Status = booking.FinishedReservationStatus
+	if err := s.UpdateReservation(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateReservation(context.Background(), newRequest("3", testDate, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// initial quotas must not overwrite persisted ones
+	s = newTestStorage(t, path)
+	defer s.Stop(context.Background())
+
+	if got := freeRooms(t, s, "eco"); got != 0 {
+		t.Errorf("free eco rooms = %d, want 0", got)
+	}
+
+	notFinished, err := s.GetNotFinishedReservations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notFinished) != 1 || notFinished[0].ID != "3" {
+		t.Errorf("GetNotFinishedReservations() = %v, want only reservation 3", notFinished)
+	}
+
+	userReservations, err := s.GetReservationsByUserID(context.Background(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userReservations) != 3 {
+		t.Errorf("GetReservationsByUserID() returned %d reservations, want 3", len(userReservations))
+	}
+
+	if _, err := s.GetReservationByID(context.Background(), "404"); !errors.Is(err, booking.ErrNotFound) {
+		t.Errorf("GetReservationByID() error = %v, want %v", err, booking.ErrNotFound)
+	}
+}
+
+func TestStorage_SagaLog(t *testing.T) {
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "booking.db"))
+	defer s.Stop(context.Background())
+
+	// ids sharing prefix must not mix their logs
+	for _, entry := range []*booking.SagaLogEntry{
+		{ReservationID: "1", Step: "price", Action: booking.RunSagaAction, Outcome: booking.SucceededSagaOutcome, Time: testDate},
+		{ReservationID: "10", Step: "price", Action: booking.RunSagaAction, Outcome: booking.ErrorSagaOutcome, Error: "timeout", Time: testDate},
+		{ReservationID: "1", Step: "payment", Action: booking.RunSagaAction, Outcome: booking.PendingSagaOutcome, Time: testDate},
+	} {
+		if err := s.AppendSagaLog(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := s.GetSagaLog(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Step != "price" || entries[1].Step != "payment" {
+		t.Errorf("GetSagaLog() = %v, want price and payment runs of reservation 1", entries)
+	}
+}
+
+func TestStorage_UpdateReservationVersion(t *testing.T) {
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "booking.db"))
+	defer s.Stop(context.Background())
+
+	if _, err := s.CreateReservation(context.Background(), newRequest("1", testDate, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.GetReservationByID(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := s.GetReservationByID(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.Status = "payment"
+	if err := s.UpdateReservation(context.Background(), first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != stale.Version+1 {
+		t.Errorf("version = %d, want %d", first.Version, stale.Version+1)
+	}
+
+	stale.Status = booking.FinishedReservationStatus
+	if err := s.UpdateReservation(context.Background(), stale); !errors.Is(err, booking.ErrConcurrentModification) {
+		t.Errorf("UpdateReservation() of stale copy error = %v, want %v", err, booking.ErrConcurrentModification)
+	}
+
+	// cancelation makes all copies stale
+	if err := s.CancelReservation(context.Background(), "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateReservation(context.Background(), first); !errors.Is(err, booking.ErrConcurrentModification) {
+		t.Errorf("UpdateReservation() after cancelation error = %v, want %v", err, booking.ErrConcurrentModification)
+	}
+}
+
+func TestStorage_IdempotencyKeyExpiration(t *testing.T) {
+	s := newTestStorage(t, filepath.Join(t.TempDir(), "booking.db"))
+	defer s.Stop(context.Background())
+
+	expiration := idempotency.Expiration{TTL: time.Hour, Lease: time.Minute}
+	now := time.Now()
+
+	for _, record := range []*idempotency.Record{
+		{UserID: "user", Key: "completed", Fingerprint: "1", StatusCode: 200, CreatedAt: now.Add(-time.Minute * 2)},
+		{UserID: "user", Key: "lost", Fingerprint: "1", CreatedAt: now.Add(-time.Minute * 2)},
+		{UserID: "user", Key: "old", Fingerprint: "1", StatusCode: 200, CreatedAt: now.Add(-time.Hour * 2)},
+	} {
+		if _, err := s.CreateIdempotencyKey(context.Background(), record, expiration); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// lost request in progress is replaced, completed key is kept until ttl
+	for _, tt := range []struct {
+		key             string
+		wantErr         error
+		wantFingerprint string
+	}{
+		{key: "completed", wantErr: idempotency.ErrKeyExists, wantFingerprint: "1"},
+		{key: "lost", wantErr: nil, wantFingerprint: "2"},
+	} {
+		record := &idempotency.Record{UserID: "user", Key: tt.key, Fingerprint: "2", CreatedAt: now}
+		stored, err := s.CreateIdempotencyKey(context.Background(), record, expiration)
+		if !errors.Is(err, tt.wantErr) || stored.Fingerprint != tt.wantFingerprint {
+			t.Errorf("CreateIdempotencyKey(%s) = %+v, %v, want fingerprint %s and error %v", tt.key, stored, err, tt.wantFingerprint, tt.wantErr)
+		}
+	}
+
+	if err := s.DeleteExpiredIdempotencyKeys(context.Background(), expiration, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// expired key is removed, so it can be created with no expiration
+	record := &idempotency.Record{UserID: "user", Key: "old", Fingerprint: "2", CreatedAt: now}
+	if _, err := s.CreateIdempotencyKey(context.Background(), record, idempotency.Expiration{}); err != nil {
+		t.Errorf("CreateIdempotencyKey(old) error = %v, expired key must be deleted", err)
+	}
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/payment"
+)
+
+// PaymentCodec restores payment details and orders from their json representation.
+// *payment.Provider implements it
+type PaymentCodec interface {
+	UnmarshalDetailsJSON(pType payment.SourceType, msg []byte) (payment.OrderDetails, error)
+	UnmarshalOrderJSON(pType payment.SourceType, reservationID string, msg []byte) (payment.Order, error)
+}
+
+// ReservationCodec converts reservation to json document and back.
+// payment fields are interfaces, so they are decoded by payment type of reservation
+type ReservationCodec struct {
+	p PaymentCodec
+}
+
+func NewReservationCodec(p PaymentCodec) *ReservationCodec {
+	return &ReservationCodec{p: p}
+}
+
+func (c *ReservationCodec) Marshal(r *booking.Reservation) ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (c *ReservationCodec) Unmarshal(data []byte) (*booking.Reservation, error) {
+	type reservation booking.Reservation
+
+	res := &booking.Reservation{}
+
+	// shallower fields with the same json names hide payment interfaces of embedded struct
+	doc := struct {
+		*reservation
+		PaymentRequestDetails json.RawMessage   `json:"payment_request,omitempty"`
+		PaymentOrder          json.RawMessage   `json:"payment_order,omitempty"`
+		Installments          []json.RawMessage `json:"installments,omitempty"`
+	}{reservation: (*reservation)(res)}
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var err error
+
+	if !isEmptyJSON(doc.PaymentRequestDetails) {
+		res.PaymentRequestDetails, err = c.p.UnmarshalDetailsJSON(res.PaymentType, doc.PaymentRequestDetails)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !isEmptyJSON(doc.PaymentOrder) {
+		res.PaymentOrder, err = c.p.UnmarshalOrderJSON(res.PaymentType, res.ID, doc.PaymentOrder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, data := range doc.Installments {
+		installment, err := c.unmarshalInstallment(res, i, data)
+		if err != nil {
+			return nil, err
+		}
+		res.Installments = append(res.Installments, installment)
+	}
+
+	return res, nil
+}
+
+// unmarshalInstallment restores installment, its order has own identifier at payment source
+func (c *ReservationCodec) unmarshalInstallment(r *booking.Reservation, index int, data []byte) (*booking.Installment, error) {
+	type installment booking.Installment
+
+	res := &booking.Installment{}
+
+	doc := struct {
+		*installment
+		Order json.RawMessage `json:"order,omitempty"`
+	}{installment: (*installment)(res)}
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	if !isEmptyJSON(doc.Order) {
+		var err error
+		res.Order, err = c.p.UnmarshalOrderJSON(r.PaymentType, booking.InstallmentOrderID(r.ID, index), doc.Order)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func isEmptyJSON(msg json.RawMessage) bool {
+	return len(msg) == 0 || string(msg) == "null"
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/money"
+	"github.com/antnmxmv/booking-service/internal/payment"
+)
+
+func TestReservationCodec(t *testing.T) {
+	p := payment.NewPaymentProvider(
+		payment.NewCashSource(),
+		payment.NewCardSource(&config.Config{Payment: config.Payment{Card: config.Card{Timeout: time.Millisecond}}}),
+	)
+	codec := NewReservationCodec(p)
+
+	details, err := p.UnmarshalDetailsJSON("card", []byte(`{"card_id": 12}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := p.UnmarshalOrderJSON("card", "1", []byte(`{"url": "http://merchant-url", "status": "pending"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deposit, err := p.UnmarshalOrderJSON("card", "3", []byte(`{"url": "http://merchant-url", "status": "success", "amount": 20, "currency": "EUR"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := p.UnmarshalOrderJSON("card", booking.InstallmentOrderID("3", 0), []byte(`{"url": "http://merchant-url", "status": "success", "amount": 80, "currency": "EUR"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		in   *booking.Reservation
+	}{
+		{
+			name: "card payment",
+			in: &booking.Reservation{
+				ID:                    "1",
+				UserID:                "user",
+				RoomTypes:             booking.RoomsRequest{{RoomType: "lux", Count: 1}},
+				PaymentType:           "card",
+				PaymentRequestDetails: details,
+				PaymentOrder:          order,
+				Status:                "payment",
+				StartDate:             time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+				Cost:                  money.New(1500, "USD"),
+				DisplayCurrency:       "EUR",
+				DisplayCost:           &money.Money{Amount: 1380, Currency: "EUR"},
+			},
+		},
+		{
+			name: "deposit and balance",
+			in: &booking.Reservation{
+				ID:           "3",
+				PaymentType:  "card",
+				PaymentOrder: deposit,
+				Installments: []*booking.Installment{
+					{Amount: money.New(80, "EUR"), DueDate: time.Date(2029, 12, 25, 0, 0, 0, 0, time.UTC), OnFailure: booking.KeepDepositFailureAction, Order: balance},
+					{Amount: money.New(20, "EUR"), DueDate: time.Date(2029, 12, 31, 0, 0, 0, 0, time.UTC), OnFailure: booking.RefundDepositFailureAction},
+				},
+				Status: booking.FinishedReservationStatus,
+			},
+		},
+		{
+			name: "no payment yet",
+			in: &booking.Reservation{
+				ID:          "2",
+				PaymentType: "cash",
+				Status:      booking.CreatedReservationStatus,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := codec.Marshal(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := codec.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			// compare json to ignore time zone pointers
+			gotJSON, _ := json.Marshal(got)
+			if string(gotJSON) != string(data) {
+				t.Errorf("Unmarshal() = %s, want %s", gotJSON, data)
+			}
+			if !reflect.DeepEqual(got.PaymentOrder, tt.in.PaymentOrder) {
+				t.Errorf("Unmarshal() order = %#v, want %#v", got.PaymentOrder, tt.in.PaymentOrder)
+			}
+			for i, installment := range tt.in.Installments {
+				if !reflect.DeepEqual(got.Installments[i].Order, installment.Order) {
+					t.Errorf("Unmarshal() installment %d order = %#v, want %#v", i, got.Installments[i].Order, installment.Order)
+				}
+			}
+		})
+	}
+}
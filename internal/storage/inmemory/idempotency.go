@@ -0,0 +1,71 @@
+package inmemory
+
+import (
+	"context"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/idempotency"
+)
+
+func idempotencyKey(userID, key string) string {
+	return userID + "\x00" + key
+}
+
+func (s *Storage) CreateIdempotencyKey(ctx context.Context, record *idempotency.Record, expiration idempotency.Expiration) (*idempotency.Record, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if stored, ok := s.idempotencyKeys[idempotencyKey(record.UserID, record.Key)]; ok && !expiration.IsExpired(stored, record.CreatedAt) {
+		res := *stored
+		return &res, idempotency.ErrKeyExists
+	}
+
+	stored := *record
+	s.idempotencyKeys[idempotencyKey(record.UserID, record.Key)] = &stored
+
+	return record, nil
+}
+
+func (s *Storage) CompleteIdempotencyKey(ctx context.Context, record *idempotency.Record) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stored := *record
+	s.idempotencyKeys[idempotencyKey(record.UserID, record.Key)] = &stored
+
+	return nil
+}
+
+func (s *Storage) DeleteIdempotencyKey(ctx context.Context, userID, key string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delete(s.idempotencyKeys, idempotencyKey(userID, key))
+
+	return nil
+}
+
+func (s *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiration idempotency.Expiration, now time.Time) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for key, record := range s.idempotencyKeys {
+		if expiration.IsExpired(record, now) {
+			delete(s.idempotencyKeys, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,356 @@
+package inmemory
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/storage"
+)
+
+const (
+	walFileName             = "wal.log"
+	snapshotFileName        = "snapshot.json"
+	defaultSnapshotInterval = time.Minute * 5
+)
+
+type operation string
+
+const (
+	createOperation  operation = "create"
+	cancelOperation  operation = "cancel"
+	updateOperation  operation = "update"
+	sagaLogOperation operation = "saga_log"
+)
+
+// journalRecord is a line of write-ahead log. it is written before mutation is applied in memory
+type journalRecord struct {
+	Operation   operation             `json:"op"`
+	Reservation json.RawMessage       `json:"reservation,omitempty"`
+	SagaLog     *booking.SagaLogEntry `json:"saga_log,omitempty"`
+	// OutboxAdd and OutboxRemove are changes of outbox made by update in the same record
+	OutboxAdd    []*booking.OutboxMessage `json:"outbox_add,omitempty"`
+	OutboxRemove []string                 `json:"outbox_remove,omitempty"`
+}
+
+// snapshot is full storage state. write-ahead log contains only mutations made after it
+type snapshot struct {
+	Reservations     []json.RawMessage                  `json:"reservations"`
+	RoomAvailability []*RoomAvailability                `json:"room_availability"`
+	SagaLog          map[string][]*booking.SagaLogEntry `json:"saga_log,omitempty"`
+	Outbox           []*booking.OutboxMessage           `json:"outbox,omitempty"`
+}
+
+// journal is append-only log of storage mutations with periodic snapshots.
+// all its methods are called under storage lock
+type journal struct {
+	cnf   *config.Config
+	codec *storage.ReservationCodec
+	wal   *os.File
+	// doneCh stops periodic snapshots
+	doneCh chan struct{}
+}
+
+// WithJournal makes storage durable when storage.inmemory.journalDir is set in config.
+// payment codec is needed to restore payment details of reservations
+func (s *Storage) WithJournal(cnf *config.Config, paymentCodec storage.PaymentCodec) *Storage {
+	s.journal = &journal{
+		cnf:   cnf,
+		codec: storage.NewReservationCodec(paymentCodec),
+	}
+	return s
+}
+
+func (j *journal) dir() string {
+	if j == nil {
+		return ""
+	}
+	return j.cnf.Storage.InMemory.JournalDir
+}
+
+// open restores storage state from snapshot and log, then starts periodic snapshots
+func (j *journal) open(s *Storage) error {
+	if j.dir() == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(j.dir(), 0700); err != nil {
+		return err
+	}
+
+	if err := j.loadSnapshot(s); err != nil {
+		return fmt.Errorf("loading snapshot: %w", err)
+	}
+
+	wal, err := os.OpenFile(filepath.Join(j.dir(), walFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := j.replay(s, wal); err != nil {
+		wal.Close()
+		return fmt.Errorf("replaying write-ahead log: %w", err)
+	}
+
+	j.wal = wal
+	j.doneCh = make(chan struct{})
+
+	interval := j.cnf.Storage.InMemory.SnapshotInterval
+	if interval <= 0 {
+		interval = defaultSnapshotInterval
+	}
+
+	go j.snapshotPeriodically(s, interval, j.doneCh)
+
+	return nil
+}
+
+// close makes final snapshot, so next start will not need to replay log
+func (j *journal) close(s *Storage) error {
+	if j == nil || j.wal == nil {
+		return nil
+	}
+
+	close(j.doneCh)
+
+	err := j.snapshot(s)
+	if closeErr := j.wal.Close(); err == nil {
+		err = closeErr
+	}
+	j.wal = nil
+
+	return err
+}
+
+// write appends reservation record to log and flushes it to disk
+func (j *journal) write(op operation, r *booking.Reservation) error {
+	if j == nil || j.wal == nil {
+		return nil
+	}
+
+	data, err := j.codec.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return j.writeRecord(journalRecord{Operation: op, Reservation: data})
+}
+
+// writeUpdate appends updated reservation together with outbox changes, so they are replayed atomically
+func (j *journal) writeUpdate(r *booking.Reservation, add []*booking.OutboxMessage, remove []string) error {
+	if j == nil || j.wal == nil {
+		return nil
+	}
+
+	data, err := j.codec.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return j.writeRecord(journalRecord{Operation: updateOperation, Reservation: data, OutboxAdd: add, OutboxRemove: remove})
+}
+
+func (j *journal) writeSagaLog(entry *booking.SagaLogEntry) error {
+	if j == nil || j.wal == nil {
+		return nil
+	}
+
+	return j.writeRecord(journalRecord{Operation: sagaLogOperation, SagaLog: entry})
+}
+
+func (j *journal) writeRecord(record journalRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.wal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return j.wal.Sync()
+}
+
+func (j *journal) loadSnapshot(s *Storage) error {
+	data, err := os.ReadFile(filepath.Join(j.dir(), snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	snap := snapshot{}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+
+	reservations := make(map[string]*booking.Reservation, len(snap.Reservations))
+	for _, doc := range snap.Reservations {
+		r, err := j.codec.Unmarshal(doc)
+		if err != nil {
+			return err
+		}
+		reservations[r.ID] = r
+	}
+
+	s.reservations = reservations
+	s.WithRoomAvailability(snap.RoomAvailability)
+	if snap.SagaLog != nil {
+		s.sagaLog = snap.SagaLog
+	}
+	s.outbox = make(map[string]*booking.OutboxMessage, len(snap.Outbox))
+	s.applyOutbox(snap.Outbox, nil)
+
+	return nil
+}
+
+// replay applies all records of log. incomplete last line is left by crash
+// in the middle of writing, it was never applied, so it is cut off
+func (j *journal) replay(s *Storage, wal *os.File) error {
+	reader := bufio.NewReader(wal)
+	offset := int64(0)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Printf("[inmemory] cutting off incomplete write-ahead log record at offset %d", offset)
+				if err := wal.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+
+		record := journalRecord{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+
+		if record.Operation == sagaLogOperation {
+			if record.SagaLog == nil {
+				return fmt.Errorf("record at offset %d: saga log entry is empty", offset)
+			}
+			if !s.hasSagaLog(record.SagaLog) {
+				s.applySagaLog(record.SagaLog)
+			}
+			offset += int64(len(line))
+			continue
+		}
+
+		r, err := j.codec.Unmarshal(record.Reservation)
+		if err != nil {
+			return fmt.Errorf("record at offset %d: %w", offset, err)
+		}
+
+		// snapshot may already contain changes of the log if it was not truncated after snapshot
+		switch record.Operation {
+		case createOperation:
+			if _, ok := s.reservations[r.ID]; !ok {
+				s.applyCreate(r)
+			}
+		case cancelOperation:
+			if stored, ok := s.reservations[r.ID]; ok && stored.Status != booking.CanceledReservationStatus {
+				s.applyCancel(stored)
+			}
+		case updateOperation:
+			s.reservations[r.ID] = r
+			s.applyOutbox(record.OutboxAdd, record.OutboxRemove)
+		default:
+			return fmt.Errorf("record at offset %d: unknown operation %q", offset, record.Operation)
+		}
+
+		offset += int64(len(line))
+	}
+
+	_, err := wal.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (j *journal) snapshotPeriodically(s *Storage, interval time.Duration, doneCh chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			s.mux.Lock()
+			// journal could be closed while waiting for lock
+			if j.wal != nil {
+				if err := j.snapshot(s); err != nil {
+					log.Printf("[inmemory] snapshot failed: %s", err.Error())
+				}
+			}
+			s.mux.Unlock()
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+// snapshot writes full state to temporary file, atomically replaces
+// previous snapshot with it and truncates log
+func (j *journal) snapshot(s *Storage) error {
+	snap := snapshot{
+		Reservations:     make([]json.RawMessage, 0, len(s.reservations)),
+		RoomAvailability: s.roomAvailability,
+		SagaLog:          s.sagaLog,
+		Outbox:           make([]*booking.OutboxMessage, 0, len(s.outbox)),
+	}
+
+	for _, m := range s.outbox {
+		snap.Outbox = append(snap.Outbox, m)
+	}
+
+	for _, r := range s.reservations {
+		data, err := j.codec.Marshal(r)
+		if err != nil {
+			return err
+		}
+		snap.Reservations = append(snap.Reservations, data)
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(j.dir(), snapshotFileName)
+
+	tmp, err := os.CreateTemp(j.dir(), snapshotFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if err := j.wal.Truncate(0); err != nil {
+		return err
+	}
+	_, err = j.wal.Seek(0, io.SeekStart)
+	return err
+}
@@ -0,0 +1,132 @@
+package inmemory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/payment"
+)
+
+var testDate = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newJournaledStorage(t *testing.T, dir string) *Storage {
+	cnf := &config.Config{Storage: config.Storage{InMemory: config.InMemory{JournalDir: dir, SnapshotInterval: time.Hour}}}
+	s := NewStorage().
+		WithRoomAvailability([]*RoomAvailability{{HotelID: "hotel", RoomType: "eco", Date: testDate, Quota: 3}}).
+		WithJournal(cnf, payment.NewPaymentProvider(payment.NewCashSource()))
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func fillStorage(t *testing.T, s *Storage) {
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := s.CreateReservation(context.Background(), &booking.ReservationRequest{
+			ID:           id,
+			UserID:       "user",
+			HotelID:      "hotel",
+			RoomsRequest: booking.RoomsRequest{{RoomType: "eco", Count: 1}},
+			PaymentType:  "cash",
+			StartDate:    testDate,
+			EndDate:      testDate,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.CancelReservation(context.Background(), "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendSagaLog(context.Background(), &booking.SagaLogEntry{ReservationID: "2", Step: "payment", Action: booking.RunSagaAction, Outcome: booking.SucceededSagaOutcome, Time: testDate}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.GetReservationByID(context.Background(), "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Status = booking.FinishedReservationStatus
+	if err := s.UpdateReservation(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRestored(t *testing.T, s *Storage) {
+	rooms, err := s.GetRoomsByDates(context.Background(), "hotel", testDate, testDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].FreeCount != 1 {
+		t.Errorf("GetRoomsByDates() = %v, want 1 free room", rooms)
+	}
+
+	notFinished, err := s.GetNotFinishedReservations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notFinished) != 1 || notFinished[0].ID != "3" {
+		t.Errorf("GetNotFinishedReservations() = %v, want only reservation 3", notFinished)
+	}
+
+	r, err := s.GetReservationByID(context.Background(), "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != booking.FinishedReservationStatus || r.UserID != "user" {
+		t.Errorf("GetReservationByID() = %+v", r)
+	}
+
+	entries, err := s.GetSagaLog(context.Background(), "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Step != "payment" || !entries[0].Time.Equal(testDate) {
+		t.Errorf("GetSagaLog() = %v, want one payment entry", entries)
+	}
+}
+
+func TestJournal_ReplayAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+
+	// storage is not stopped, so there is no snapshot and everything is in log
+	fillStorage(t, newJournaledStorage(t, dir))
+
+	// crash in the middle of writing a record
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wal.WriteString(`{"op":"cancel","reserva`); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	s := newJournaledStorage(t, dir)
+	defer s.Stop(context.Background())
+
+	checkRestored(t, s)
+}
+
+func TestJournal_Snapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newJournaledStorage(t, dir)
+	fillStorage(t, s)
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, walFileName)); err != nil || info.Size() != 0 {
+		t.Errorf("write-ahead log must be empty after snapshot, err: %v", err)
+	}
+
+	s = newJournaledStorage(t, dir)
+	defer s.Stop(context.Background())
+
+	checkRestored(t, s)
+}
@@ -1,24 +1,35 @@
 package inmemory
 
 import (
+	"context"
 	"errors"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/idempotency"
 )
 
 type Storage struct {
 	reservations     map[string]*booking.Reservation
 	roomAvailability []*RoomAvailability
-	mux              sync.RWMutex
+	sagaLog          map[string][]*booking.SagaLogEntry
+	outbox           map[string]*booking.OutboxMessage
+	// idempotencyKeys are by user id and key, they are not written to journal
+	idempotencyKeys map[string]*idempotency.Record
+	// journal makes storage durable when configured, nil journal does nothing
+	journal *journal
+	mux     sync.RWMutex
 }
 
 func NewStorage() *Storage {
 	return &Storage{
 		reservations:     map[string]*booking.Reservation{},
 		roomAvailability: []*RoomAvailability{},
+		sagaLog:          map[string][]*booking.SagaLogEntry{},
+		outbox:           map[string]*booking.OutboxMessage{},
+		idempotencyKeys:  map[string]*idempotency.Record{},
 	}
 }
 
@@ -52,10 +63,28 @@ func (s *Storage) Repository() booking.Repository {
 	return s
 }
 
-func (s *Storage) CreateReservation(reservation *booking.ReservationRequest) (*booking.Reservation, error) {
+// Start restores data from snapshot and write-ahead log if journal is configured
+func (s *Storage) Start(_ context.Context) error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
+	return s.journal.open(s)
+}
+
+func (s *Storage) Stop(_ context.Context) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.journal.close(s)
+}
+
+func (s *Storage) CreateReservation(ctx context.Context, reservation *booking.ReservationRequest) (*booking.Reservation, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	if _, ok := s.reservations[reservation.ID]; ok {
 		return nil, booking.ErrDuplicate
 	}
@@ -85,38 +114,80 @@ func (s *Storage) CreateReservation(reservation *booking.ReservationRequest) (*b
 		return nil, booking.ErrNotWorkingDays
 	}
 
-	for i := startIndex; i < len(s.roomAvailability) && !s.roomAvailability[i].Date.After(reservation.EndDate); i++ {
-		if !s.roomAvailability[i].Date.After(reservation.EndDate) &&
-			s.roomAvailability[i].HotelID == reservation.HotelID &&
-			needRoomTypes[s.roomAvailability[i].RoomType] > 0 {
-			s.roomAvailability[i].Quota -= needRoomTypes[s.roomAvailability[i].RoomType]
-		}
-	}
-
 	result := &booking.Reservation{
 		ID:                    reservation.ID,
+		UserID:                reservation.UserID,
 		HotelID:               reservation.HotelID,
 		RoomTypes:             reservation.RoomsRequest,
 		StartDate:             reservation.StartDate,
 		PaymentType:           reservation.PaymentType,
 		PaymentRequestDetails: reservation.PaymentDetails,
 		EndDate:               reservation.EndDate,
+		DisplayCurrency:       reservation.DisplayCurrency,
 		Status:                booking.CreatedReservationStatus,
 		LastUpdateTime:        time.Now(),
+		Version:               1,
+	}
+
+	if err := s.journal.write(createOperation, result); err != nil {
+		return nil, err
+	}
+
+	s.applyCreate(result)
+
+	return result.Copy(), nil
+}
+
+// applyCreate takes rooms quota and saves reservation which is already checked
+func (s *Storage) applyCreate(reservation *booking.Reservation) {
+	needRoomTypes := make(map[string]uint, len(reservation.RoomTypes))
+
+	for _, roomRequest := range reservation.RoomTypes {
+		needRoomTypes[roomRequest.RoomType] += roomRequest.Count
 	}
 
-	s.reservations[result.ID] = result
+	startIndex := s.getStartIndex(reservation.StartDate)
+
+	for i := startIndex; i < len(s.roomAvailability) && !s.roomAvailability[i].Date.After(reservation.EndDate); i++ {
+		if s.roomAvailability[i].HotelID == reservation.HotelID &&
+			needRoomTypes[s.roomAvailability[i].RoomType] > 0 {
+			s.roomAvailability[i].Quota -= needRoomTypes[s.roomAvailability[i].RoomType]
+		}
+	}
 
-	return result, nil
+	s.reservations[reservation.ID] = reservation
 }
 
-func (s *Storage) CancelReservation(reservationID string) error {
+func (s *Storage) CancelReservation(ctx context.Context, reservationID string) error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	reservation, ok := s.reservations[reservationID]
+	if !ok {
+		return booking.ErrNotFound
+	}
+
+	// quota must be released only once
+	if reservation.Status == booking.CanceledReservationStatus {
+		return nil
+	}
+
+	if err := s.journal.write(cancelOperation, reservation); err != nil {
+		return err
+	}
+
+	s.applyCancel(reservation)
 
-	reservation := s.reservations[reservationID]
+	return nil
+}
 
+// applyCancel marks reservation canceled and releases its rooms quota
+func (s *Storage) applyCancel(reservation *booking.Reservation) {
 	reservation.Status = booking.CanceledReservationStatus
+	reservation.Version++
 
 	startIndex := s.getStartIndex(reservation.StartDate)
 
@@ -133,16 +204,21 @@ func (s *Storage) CancelReservation(reservationID string) error {
 			s.roomAvailability[i].Quota += count
 		}
 	}
+}
 
-	return nil
+func (s *Storage) UpdateReservation(ctx context.Context, update *booking.Reservation) error {
+	return s.UpdateReservationWithOutbox(ctx, update, nil, nil)
 }
 
-func (s *Storage) UpdateReservation(update *booking.Reservation) error {
+func (s *Storage) UpdateReservationWithOutbox(ctx context.Context, update *booking.Reservation, add []*booking.OutboxMessage, remove []string) error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r, ok := s.reservations[update.ID]
 	if !ok {
-		return errors.New("not found")
+		return booking.ErrNotFound
 	}
 	for i, rt := range update.RoomTypes {
 		if r.RoomTypes[i] != rt {
@@ -150,15 +226,60 @@ func (s *Storage) UpdateReservation(update *booking.Reservation) error {
 		}
 	}
 
-	s.reservations[update.ID] = update
+	if r.Version != update.Version {
+		return booking.ErrConcurrentModification
+	}
+
+	next := update.Copy()
+	next.Version++
+
+	if err := s.journal.writeUpdate(next, add, remove); err != nil {
+		return err
+	}
+
+	s.reservations[update.ID] = next
+	s.applyOutbox(add, remove)
+	update.Version = next.Version
 
 	return nil
 }
 
-func (s *Storage) GetRoomsByDates(hotelID string, startDate, endDate time.Time) ([]*booking.RoomAvailability, error) {
+func (s *Storage) applyOutbox(add []*booking.OutboxMessage, remove []string) {
+	for _, m := range add {
+		stored := *m
+		s.outbox[m.ID] = &stored
+	}
+	for _, id := range remove {
+		delete(s.outbox, id)
+	}
+}
+
+func (s *Storage) GetOutboxMessages(ctx context.Context) ([]*booking.OutboxMessage, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	res := make([]*booking.OutboxMessage, 0, len(s.outbox))
+	for _, m := range s.outbox {
+		stored := *m
+		res = append(res, &stored)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+
+	return res, nil
+}
+
+func (s *Storage) GetRoomsByDates(ctx context.Context, hotelID string, startDate, endDate time.Time) ([]*booking.RoomAvailability, error) {
 	res := make([]*booking.RoomAvailability, 0, int(endDate.Sub(startDate).Hours())/24)
 	s.mux.RLock()
 	defer s.mux.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	for i := len(s.roomAvailability) - 1; i >= 0; i-- {
 		if s.roomAvailability[i].Date.Before(startDate) {
@@ -178,41 +299,97 @@ func (s *Storage) GetRoomsByDates(hotelID string, startDate, endDate time.Time)
 	return res, nil
 }
 
-func (s *Storage) GetReservationByID(id string) (*booking.Reservation, error) {
+func (s *Storage) GetReservationByID(ctx context.Context, id string) (*booking.Reservation, error) {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	res, ok := s.reservations[id]
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, booking.ErrNotFound
 	}
-	return res, nil
+	return res.Copy(), nil
 }
 
-func (s *Storage) GetReservationsByUserID(userID string) ([]*booking.Reservation, error) {
+func (s *Storage) GetReservationsByUserID(ctx context.Context, userID string) ([]*booking.Reservation, error) {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	res := []*booking.Reservation{}
 
 	for _, reservation := range s.reservations {
 		if reservation.UserID == userID {
-			res = append(res, reservation)
+			res = append(res, reservation.Copy())
 		}
 	}
 
 	return res, nil
 }
 
-func (s *Storage) GetNotFinishedReservations() ([]*booking.Reservation, error) {
+func (s *Storage) GetNotFinishedReservations(ctx context.Context) ([]*booking.Reservation, error) {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	res := []*booking.Reservation{}
 
 	for _, reservation := range s.reservations {
 		if reservation.Status != booking.CanceledReservationStatus &&
 			reservation.Status != booking.FinishedReservationStatus {
-			res = append(res, reservation)
+			res = append(res, reservation.Copy())
+		}
+	}
+
+	return res, nil
+}
+
+func (s *Storage) AppendSagaLog(ctx context.Context, entry *booking.SagaLogEntry) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := s.journal.writeSagaLog(entry); err != nil {
+		return err
+	}
+
+	s.applySagaLog(entry)
+
+	return nil
+}
+
+func (s *Storage) applySagaLog(entry *booking.SagaLogEntry) {
+	stored := *entry
+	s.sagaLog[entry.ReservationID] = append(s.sagaLog[entry.ReservationID], &stored)
+}
+
+// hasSagaLog checks if entry is already appended, entries of one reservation have distinct time
+func (s *Storage) hasSagaLog(entry *booking.SagaLogEntry) bool {
+	for _, stored := range s.sagaLog[entry.ReservationID] {
+		if stored.Time.Equal(entry.Time) && stored.Step == entry.Step && stored.Action == entry.Action {
+			return true
 		}
 	}
+	return false
+}
+
+func (s *Storage) GetSagaLog(ctx context.Context, reservationID string) ([]*booking.SagaLogEntry, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	res := make([]*booking.SagaLogEntry, len(s.sagaLog[reservationID]))
+	for i, entry := range s.sagaLog[reservationID] {
+		e := *entry
+		res[i] = &e
+	}
 
 	return res, nil
 }
@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/idempotency"
+)
+
+func (s *Storage) CreateIdempotencyKey(ctx context.Context, record *idempotency.Record, expiration idempotency.Expiration) (*idempotency.Record, error) {
+	completedBefore, inProgressBefore := expiration.Cutoffs(record.CreatedAt)
+
+	// expired key is replaced by new record
+	inserted, err := s.db.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint, status_code, body, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint, status_code = EXCLUDED.status_code,
+			body = EXCLUDED.body, created_at = EXCLUDED.created_at
+		WHERE (idempotency_keys.status_code <> 0 AND idempotency_keys.created_at < $7)
+			OR (idempotency_keys.status_code = 0 AND idempotency_keys.created_at < $8)`,
+		record.UserID, record.Key, record.Fingerprint, record.StatusCode, record.Body, record.CreatedAt,
+		completedBefore, inProgressBefore,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := inserted.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 1 {
+		return record, nil
+	}
+
+	stored := &idempotency.Record{UserID: record.UserID, Key: record.Key}
+	err = s.db.QueryRowContext(ctx, `
+		SELECT fingerprint, status_code, body, created_at FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`,
+		record.UserID, record.Key,
+	).Scan(&stored.Fingerprint, &stored.StatusCode, &stored.Body, &stored.CreatedAt)
+	if err != nil {
+		// key could be deleted meanwhile
+		return nil, err
+	}
+
+	return stored, idempotency.ErrKeyExists
+}
+
+func (s *Storage) CompleteIdempotencyKey(ctx context.Context, record *idempotency.Record) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET status_code = $3, body = $4
+		WHERE user_id = $1 AND key = $2`,
+		record.UserID, record.Key, record.StatusCode, record.Body,
+	)
+	return err
+}
+
+func (s *Storage) DeleteIdempotencyKey(ctx context.Context, userID, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`, userID, key)
+	return err
+}
+
+func (s *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context, expiration idempotency.Expiration, now time.Time) error {
+	completedBefore, inProgressBefore := expiration.Cutoffs(now)
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE (status_code <> 0 AND created_at < $1) OR (status_code = 0 AND created_at < $2)`,
+		completedBefore, inProgressBefore,
+	)
+	return err
+}
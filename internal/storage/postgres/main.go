@@ -0,0 +1,483 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/storage"
+	"github.com/antnmxmv/booking-service/internal/storage/inmemory"
+	"github.com/lib/pq"
+)
+
+const dateLayout = "2006-01-02"
+
+// Storage is booking.Repository on top of PostgreSQL.
+// quotas are stored per hotel, room type and date. every operation with them
+// locks affected rows, so concurrent reservations can not overbook rooms
+type Storage struct {
+	cnf   *config.Config
+	codec *storage.ReservationCodec
+	db    *sql.DB
+	// roomAvailability are initial quotas written on start
+	roomAvailability []*inmemory.RoomAvailability
+}
+
+func NewStorage(cnf *config.Config, paymentCodec storage.PaymentCodec) *Storage {
+	return &Storage{
+		cnf:   cnf,
+		codec: storage.NewReservationCodec(paymentCodec),
+	}
+}
+
+// WithRoomAvailability adds initial quotas. they are written on start only if
+// there is no quota for the same hotel, date and room type in database
+func (s *Storage) WithRoomAvailability(roomAvailability []*inmemory.RoomAvailability) *Storage {
+	s.roomAvailability = roomAvailability
+	return s
+}
+
+// Start connects to database, applies migrations and writes initial quotas
+func (s *Storage) Start(ctx context.Context) error {
+	db, err := sql.Open("postgres", s.cnf.Storage.Postgres.DSN)
+	if err != nil {
+		return err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return err
+	}
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return err
+	}
+	if err := seedQuotas(ctx, db, s.roomAvailability); err != nil {
+		db.Close()
+		return err
+	}
+	s.db = db
+	return nil
+}
+
+// seedQuotas writes initial quotas, quotas changed by reservations are kept
+func seedQuotas(ctx context.Context, db *sql.DB, roomAvailability []*inmemory.RoomAvailability) error {
+	if len(roomAvailability) == 0 {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range roomAvailability {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO room_availability (hotel_id, room_type, date, quota) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (hotel_id, date, room_type) DO NOTHING`,
+			r.HotelID, r.RoomType, day(r.Date), r.Quota)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) Stop(_ context.Context) error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func (s *Storage) CreateReservation(ctx context.Context, reservation *booking.ReservationRequest) (*booking.Reservation, error) {
+	result := &booking.Reservation{
+		ID:                    reservation.ID,
+		UserID:                reservation.UserID,
+		HotelID:               reservation.HotelID,
+		RoomTypes:             reservation.RoomsRequest,
+		StartDate:             reservation.StartDate,
+		PaymentType:           reservation.PaymentType,
+		PaymentRequestDetails: reservation.PaymentDetails,
+		EndDate:               reservation.EndDate,
+		DisplayCurrency:       reservation.DisplayCurrency,
+		Status:                booking.CreatedReservationStatus,
+		LastUpdateTime:        time.Now(),
+		Version:               1,
+	}
+
+	data, err := s.codec.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// inserting first makes duplicates fail before any quota lock is taken
+	inserted, err := tx.ExecContext(ctx, `
+		INSERT INTO reservations (id, user_id, hotel_id, status, start_date, end_date, last_update, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO NOTHING`,
+		result.ID, result.UserID, result.HotelID, result.Status,
+		day(result.StartDate), day(result.EndDate), result.LastUpdateTime, data,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := inserted.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, booking.ErrDuplicate
+	}
+
+	needRoomTypes := roomTypesCount(reservation.RoomsRequest)
+
+	quotas, err := lockQuotas(ctx, tx, reservation.HotelID, reservation.StartDate, reservation.EndDate, needRoomTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	daysToBook := int(reservation.EndDate.Sub(reservation.StartDate).Hours()/24) + 1
+	if len(quotas) < len(needRoomTypes)*daysToBook {
+		return nil, booking.ErrNotWorkingDays
+	}
+
+	for _, q := range quotas {
+		if needRoomTypes[q.roomType] > q.quota {
+			return nil, booking.WrapError(booking.ErrAlreadyBooked, q.date.String())
+		}
+	}
+
+	if err := changeQuotas(ctx, tx, reservation.HotelID, reservation.StartDate, reservation.EndDate, needRoomTypes, -1); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *Storage) CancelReservation(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	reservation, err := s.getReservationForUpdate(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	// quota must be released only once
+	if reservation.Status == booking.CanceledReservationStatus {
+		return nil
+	}
+
+	reservation.Status = booking.CanceledReservationStatus
+	reservation.Version++
+
+	if err := s.saveReservation(ctx, tx, reservation); err != nil {
+		return err
+	}
+
+	roomTypeCount := roomTypesCount(reservation.RoomTypes)
+
+	if _, err := lockQuotas(ctx, tx, reservation.HotelID, reservation.StartDate, reservation.EndDate, roomTypeCount); err != nil {
+		return err
+	}
+
+	if err := changeQuotas(ctx, tx, reservation.HotelID, reservation.StartDate, reservation.EndDate, roomTypeCount, 1); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) UpdateReservation(ctx context.Context, update *booking.Reservation) error {
+	return s.UpdateReservationWithOutbox(ctx, update, nil, nil)
+}
+
+func (s *Storage) UpdateReservationWithOutbox(ctx context.Context, update *booking.Reservation, add []*booking.OutboxMessage, remove []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	r, err := s.getReservationForUpdate(ctx, tx, update.ID)
+	if err != nil {
+		return err
+	}
+
+	for i, rt := range update.RoomTypes {
+		if i >= len(r.RoomTypes) || r.RoomTypes[i] != rt {
+			return errors.New("room types can not be updated. please create new reservation")
+		}
+	}
+
+	// row is locked, so version can not change until commit
+	if r.Version != update.Version {
+		return booking.ErrConcurrentModification
+	}
+
+	next := *update
+	next.Version++
+
+	if err := s.saveReservation(ctx, tx, &next); err != nil {
+		return err
+	}
+
+	for _, m := range add {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO outbox (id, reservation_id, step, attempt, created_at)
+			VALUES ($1, $2, $3, $4, $5)`,
+			m.ID, m.ReservationID, m.Step, m.Attempt, m.CreatedAt,
+		); err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM outbox WHERE id = ANY($1)`, pq.Array(remove)); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	update.Version = next.Version
+	return nil
+}
+
+func (s *Storage) GetOutboxMessages(ctx context.Context) ([]*booking.OutboxMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, reservation_id, step, attempt, created_at FROM outbox ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*booking.OutboxMessage{}
+
+	for rows.Next() {
+		m := &booking.OutboxMessage{}
+		if err := rows.Scan(&m.ID, &m.ReservationID, &m.Step, &m.Attempt, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *Storage) GetRoomsByDates(ctx context.Context, hotelID string, startDate, endDate time.Time) ([]*booking.RoomAvailability, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT date, room_type, quota
+		FROM room_availability
+		WHERE hotel_id = $1 AND date BETWEEN $2 AND $3 AND quota > 0
+		ORDER BY date DESC, room_type`,
+		hotelID, day(startDate), day(endDate),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*booking.RoomAvailability, 0, int(endDate.Sub(startDate).Hours())/24)
+
+	for rows.Next() {
+		r := &booking.RoomAvailability{}
+		if err := rows.Scan(&r.Date, &r.Type, &r.FreeCount); err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *Storage) GetNotFinishedReservations(ctx context.Context) ([]*booking.Reservation, error) {
+	return s.queryReservations(ctx, `
+		SELECT data FROM reservations WHERE status NOT IN ($1, $2)`,
+		booking.FinishedReservationStatus, booking.CanceledReservationStatus,
+	)
+}
+
+func (s *Storage) GetReservationByID(ctx context.Context, id string) (*booking.Reservation, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM reservations WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, booking.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return s.codec.Unmarshal(data)
+}
+
+func (s *Storage) GetReservationsByUserID(ctx context.Context, userID string) ([]*booking.Reservation, error) {
+	return s.queryReservations(ctx, `SELECT data FROM reservations WHERE user_id = $1`, userID)
+}
+
+func (s *Storage) AppendSagaLog(ctx context.Context, entry *booking.SagaLogEntry) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO saga_log (reservation_id, step, action, outcome, error, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		entry.ReservationID, entry.Step, entry.Action, entry.Outcome, entry.Error, entry.Time,
+	)
+	return err
+}
+
+func (s *Storage) GetSagaLog(ctx context.Context, reservationID string) ([]*booking.SagaLogEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT step, action, outcome, error, created_at FROM saga_log
+		WHERE reservation_id = $1 ORDER BY id`,
+		reservationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*booking.SagaLogEntry{}
+
+	for rows.Next() {
+		entry := &booking.SagaLogEntry{ReservationID: reservationID}
+		if err := rows.Scan(&entry.Step, &entry.Action, &entry.Outcome, &entry.Error, &entry.Time); err != nil {
+			return nil, err
+		}
+		res = append(res, entry)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *Storage) queryReservations(ctx context.Context, query string, args ...any) ([]*booking.Reservation, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*booking.Reservation{}
+
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		r, err := s.codec.Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *Storage) getReservationForUpdate(ctx context.Context, tx *sql.Tx, id string) (*booking.Reservation, error) {
+	var data []byte
+	err := tx.QueryRowContext(ctx, `SELECT data FROM reservations WHERE id = $1 FOR UPDATE`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, booking.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return s.codec.Unmarshal(data)
+}
+
+func (s *Storage) saveReservation(ctx context.Context, tx *sql.Tx, r *booking.Reservation) error {
+	data, err := s.codec.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE reservations
+		SET user_id = $2, hotel_id = $3, status = $4, start_date = $5, end_date = $6, last_update = $7, data = $8
+		WHERE id = $1`,
+		r.ID, r.UserID, r.HotelID, r.Status, day(r.StartDate), day(r.EndDate), r.LastUpdateTime, data,
+	)
+	return err
+}
+
+type quota struct {
+	date     time.Time
+	roomType string
+	quota    uint
+}
+
+// lockQuotas selects quotas of given room types for update.
+// rows are locked in the same order by every transaction to avoid deadlocks
+func lockQuotas(ctx context.Context, tx *sql.Tx, hotelID string, startDate, endDate time.Time, roomTypes map[string]uint) ([]quota, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT date, room_type, quota
+		FROM room_availability
+		WHERE hotel_id = $1 AND date BETWEEN $2 AND $3 AND room_type = ANY($4)
+		ORDER BY date, room_type
+		FOR UPDATE`,
+		hotelID, day(startDate), day(endDate), pq.Array(roomTypeNames(roomTypes)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []quota{}
+
+	for rows.Next() {
+		q := quota{}
+		if err := rows.Scan(&q.date, &q.roomType, &q.quota); err != nil {
+			return nil, err
+		}
+		res = append(res, q)
+	}
+
+	return res, rows.Err()
+}
+
+// changeQuotas adds sign * count of rooms to quota of every room type in dates range
+func changeQuotas(ctx context.Context, tx *sql.Tx, hotelID string, startDate, endDate time.Time, roomTypes map[string]uint, sign int) error {
+	for roomType, count := range roomTypes {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE room_availability
+			SET quota = quota + $1
+			WHERE hotel_id = $2 AND room_type = $3 AND date BETWEEN $4 AND $5`,
+			sign*int(count), hotelID, roomType, day(startDate), day(endDate),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func roomTypesCount(rooms booking.RoomsRequest) map[string]uint {
+	res := make(map[string]uint, len(rooms))
+	for _, r := range rooms {
+		res[r.RoomType] += r.Count
+	}
+	return res
+}
+
+func roomTypeNames(roomTypes map[string]uint) []string {
+	res := make([]string, 0, len(roomTypes))
+	for roomType := range roomTypes {
+		res = append(res, roomType)
+	}
+	return res
+}
+
+// day formats date without time zone, so postgres would not shift it by session time zone
+func day(t time.Time) string {
+	return t.UTC().Format(dateLayout)
+}
@@ -0,0 +1,271 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/idempotency"
+	"github.com/antnmxmv/booking-service/internal/payment"
+	"github.com/antnmxmv/booking-service/internal/storage/inmemory"
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+)
+
+const testHotelID = "hotel"
+
+// testDSN is database of tests. it is POSTGRES_TEST_DSN if it is set, otherwise
+// TestMain starts embedded postgres, its binaries are downloaded on first run
+var testDSN = os.Getenv("POSTGRES_TEST_DSN")
+
+// skipTests is set by POSTGRES_TEST_SKIP where database can't be started, e.g. without network.
+// otherwise tests fail if database is not available, so they are not skipped silently
+var skipTests = os.Getenv("POSTGRES_TEST_SKIP") != ""
+
+// testDBErr tells why database of tests is not available
+var testDBErr error
+
+func TestMain(m *testing.M) {
+	var db *embeddedpostgres.EmbeddedPostgres
+	if testDSN == "" && !skipTests {
+		cnf := embeddedpostgres.DefaultConfig().
+			Port(54329).
+			RuntimePath(filepath.Join(os.TempDir(), "booking-service-postgres")).
+			Logger(io.Discard)
+		db = embeddedpostgres.NewDatabase(cnf)
+		if testDBErr = db.Start(); testDBErr == nil {
+			testDSN = cnf.GetConnectionURL() + "?sslmode=disable"
+		}
+	}
+
+	code := m.Run()
+
+	if db != nil && testDBErr == nil {
+		_ = db.Stop()
+	}
+	os.Exit(code)
+}
+
+// newTestStorage connects to database of tests and cleans it up
+func newTestStorage(t *testing.T) *Storage {
+	if skipTests {
+		t.Skip("POSTGRES_TEST_SKIP is set")
+	}
+	if testDBErr != nil {
+		// e.g. binaries can not be downloaded without network
+		t.Fatalf("embedded postgres is not started, set POSTGRES_TEST_DSN or POSTGRES_TEST_SKIP: %s", testDBErr.Error())
+	}
+
+	cnf := &config.Config{Storage: config.Storage{Postgres: config.Postgres{DSN: testDSN}}}
+
+	s := NewStorage(cnf, payment.NewPaymentProvider(payment.NewCashSource()))
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("starting storage: %s", err.Error())
+	}
+	t.Cleanup(func() { s.Stop(context.Background()) })
+
+	if _, err := s.db.Exec(`TRUNCATE reservations, room_availability, saga_log, idempotency_keys, outbox`); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func setQuota(t *testing.T, s *Storage, roomType string, date time.Time, quota uint) {
+	_, err := s.db.Exec(`INSERT INTO room_availability (hotel_id, room_type, date, quota) VALUES ($1, $2, $3, $4)`,
+		testHotelID, roomType, day(date), quota)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newRequest(id string, date time.Time, rooms uint) *booking.ReservationRequest {
+	return &booking.ReservationRequest{
+		ID:           id,
+		UserID:       "user",
+		HotelID:      testHotelID,
+		RoomsRequest: booking.RoomsRequest{{RoomType: "eco", Count: rooms}},
+		PaymentType:  "cash",
+		StartDate:    date,
+		EndDate:      date,
+	}
+}
+
+func TestStorage_CreateReservation(t *testing.T) {
+	s := newTestStorage(t)
+	date := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	setQuota(t, s, "eco", date, 2)
+
+	if _, err := s.CreateReservation(context.Background(), newRequest("1", date, 1)); err != nil {
+		t.Fatalf("CreateReservation() error = %v", err)
+	}
+
+	if _, err := s.CreateReservation(context.Background(), newRequest("1", date, 1)); !errors.Is(err, booking.ErrDuplicate) {
+		t.Errorf("CreateReservation() error = %v, want %v", err, booking.ErrDuplicate)
+	}
+
+	if _, err := s.CreateReservation(context.Background(), newRequest("2", date, 2)); !errors.Is(err, booking.ErrAlreadyBooked) {
+		t.Errorf("CreateReservation() error = %v, want %v", err, booking.ErrAlreadyBooked)
+	}
+
+	if _, err := s.CreateReservation(context.Background(), newRequest("3", date.Add(time.Hour*24), 1)); !errors.Is(err, booking.ErrNotWorkingDays) {
+		t.Errorf("CreateReservation() error = %v, want %v", err, booking.ErrNotWorkingDays)
+	}
+
+	rooms, err := s.GetRoomsByDates(context.Background(), testHotelID, date, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].FreeCount != 1 {
+		t.Errorf("GetRoomsByDates() = %v, want one room left", rooms)
+	}
+
+	r, err := s.GetReservationByID(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.UserID != "user" || r.Status != booking.CreatedReservationStatus {
+		t.Errorf("GetReservationByID() = %+v", r)
+	}
+}
+
+func TestStorage_CreateReservationConcurrently(t *testing.T) {
+	s := newTestStorage(t)
+	date := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	setQuota(t, s, "eco", date, 3)
+
+	wg := sync.WaitGroup{}
+	mux := sync.Mutex{}
+	created := 0
+
+	for _, id := range []string{"1", "2", "3", "4", "5", "6"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if _, err := s.CreateReservation(context.Background(), newRequest(id, date, 1)); err == nil {
+				mux.Lock()
+				created++
+				mux.Unlock()
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	if created != 3 {
+		t.Errorf("created %d reservations, want 3", created)
+	}
+}
+
+func TestStorage_CancelReservation(t *testing.T) {
+	s := newTestStorage(t)
+	date := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	setQuota(t, s, "eco", date, 1)
+
+	if _, err := s.CreateReservation(context.Background(), newRequest("1", date, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// second cancelation must not release quota again
+	for i := 0; i < 2; i++ {
+		if err := s.CancelReservation(context.Background(), "1"); err != nil {
+			t.Fatalf("CancelReservation() error = %v", err)
+		}
+	}
+
+	rooms, err := s.GetRoomsByDates(context.Background(), testHotelID, date, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].FreeCount != 1 {
+		t.Errorf("GetRoomsByDates() = %v, want quota restored", rooms)
+	}
+
+	notFinished, err := s.GetNotFinishedReservations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notFinished) != 0 {
+		t.Errorf("GetNotFinishedReservations() = %v, want empty", notFinished)
+	}
+
+	if err := s.CancelReservation(context.Background(), "404"); !errors.Is(err, booking.ErrNotFound) {
+		t.Errorf("CancelReservation() error = %v, want %v", err, booking.ErrNotFound)
+	}
+}
+
+func TestStorage_WithRoomAvailability(t *testing.T) {
+	s := newTestStorage(t)
+	date := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	quotas := []*inmemory.RoomAvailability{{HotelID: testHotelID, RoomType: "eco", Date: date, Quota: 2}}
+
+	start := func() *Storage {
+		seeded := NewStorage(s.cnf, payment.NewPaymentProvider(payment.NewCashSource())).WithRoomAvailability(quotas)
+		if err := seeded.Start(context.Background()); err != nil {
+			t.Fatalf("starting storage: %s", err.Error())
+		}
+		t.Cleanup(func() { seeded.Stop(context.Background()) })
+		return seeded
+	}
+
+	if _, err := start().CreateReservation(context.Background(), newRequest("1", date, 1)); err != nil {
+		t.Fatalf("CreateReservation() error = %v", err)
+	}
+
+	// quota changed by reservation is not overwritten on restart
+	rooms, err := start().GetRoomsByDates(context.Background(), testHotelID, date, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].FreeCount != 1 {
+		t.Errorf("GetRoomsByDates() = %+v, want 1 free room", rooms)
+	}
+}
+
+func TestStorage_IdempotencyKeyExpiration(t *testing.T) {
+	s := newTestStorage(t)
+
+	expiration := idempotency.Expiration{TTL: time.Hour, Lease: time.Minute}
+	now := time.Now()
+
+	for _, record := range []*idempotency.Record{
+		{UserID: "user", Key: "completed", Fingerprint: "1", StatusCode: 200, CreatedAt: now.Add(-time.Minute * 2)},
+		{UserID: "user", Key: "lost", Fingerprint: "1", CreatedAt: now.Add(-time.Minute * 2)},
+		{UserID: "user", Key: "old", Fingerprint: "1", StatusCode: 200, CreatedAt: now.Add(-time.Hour * 2)},
+	} {
+		if _, err := s.CreateIdempotencyKey(context.Background(), record, expiration); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// lost request in progress is replaced, completed key is kept until ttl
+	for _, tt := range []struct {
+		key             string
+		wantErr         error
+		wantFingerprint string
+	}{
+		{key: "completed", wantErr: idempotency.ErrKeyExists, wantFingerprint: "1"},
+		{key: "lost", wantErr: nil, wantFingerprint: "2"},
+	} {
+		record := &idempotency.Record{UserID: "user", Key: tt.key, Fingerprint: "2", CreatedAt: now}
+		stored, err := s.CreateIdempotencyKey(context.Background(), record, expiration)
+		if !errors.Is(err, tt.wantErr) || stored.Fingerprint != tt.wantFingerprint {
+			t.Errorf("CreateIdempotencyKey(%s) = %+v, %v, want fingerprint %s and error %v", tt.key, stored, err, tt.wantFingerprint, tt.wantErr)
+		}
+	}
+
+	if err := s.DeleteExpiredIdempotencyKeys(context.Background(), expiration, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// expired key is removed, so it can be created with no expiration
+	record := &idempotency.Record{UserID: "user", Key: "old", Fingerprint: "2", CreatedAt: now}
+	if _, err := s.CreateIdempotencyKey(context.Background(), record, idempotency.Expiration{}); err != nil {
+		t.Errorf("CreateIdempotencyKey(old) error = %v, expired key must be deleted", err)
+	}
+}
@@ -0,0 +1,97 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationsLockID is key of advisory lock which prevents concurrent migrations by several instances
+const migrationsLockID = 7_340_211
+
+type migration struct {
+	version int
+	name    string
+	query   string
+}
+
+// loadMigrations reads embedded migration files named like 0001_description.sql
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationsFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]migration, 0, len(entries))
+
+	for _, entry := range entries {
+		name := entry.Name()
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no version prefix", name)
+		}
+		query, err := migrationsFS.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, migration{version: version, name: name, query: string(query)})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].version < res[j].version
+	})
+
+	return res, nil
+}
+
+// migrate applies all not applied migrations in one transaction
+func migrate(ctx context.Context, db *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationsLockID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER     PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	var lastVersion int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&lastVersion); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= lastVersion {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, m.query); err != nil {
+			return fmt.Errorf("applying migration %s: %w", m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, m.version); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
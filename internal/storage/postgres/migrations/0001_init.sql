@@ -0,0 +1,24 @@
+CREATE TABLE room_availability (
+    hotel_id  TEXT    NOT NULL,
+    room_type TEXT    NOT NULL,
+    date      DATE    NOT NULL,
+    quota     INTEGER NOT NULL CHECK (quota >= 0),
+    PRIMARY KEY (hotel_id, date, room_type)
+);
+
+CREATE TABLE reservations (
+    id          TEXT        PRIMARY KEY,
+    user_id     TEXT        NOT NULL,
+    hotel_id    TEXT        NOT NULL,
+    status      TEXT        NOT NULL,
+    start_date  DATE        NOT NULL,
+    end_date    DATE        NOT NULL,
+    last_update TIMESTAMPTZ NOT NULL,
+    -- data is full reservation document, columns above are used for search only
+    data        JSONB       NOT NULL
+);
+
+CREATE INDEX reservations_user_id_idx ON reservations (user_id);
+
+CREATE INDEX reservations_not_finished_idx ON reservations (status)
+    WHERE status NOT IN ('finished', 'canceled');
@@ -0,0 +1,11 @@
+CREATE TABLE saga_log (
+    id             BIGSERIAL   PRIMARY KEY,
+    reservation_id TEXT        NOT NULL,
+    step           TEXT        NOT NULL,
+    action         TEXT        NOT NULL,
+    outcome        TEXT        NOT NULL,
+    error          TEXT        NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX saga_log_reservation_id_idx ON saga_log (reservation_id, id);
@@ -0,0 +1,10 @@
+CREATE TABLE idempotency_keys (
+    user_id     TEXT        NOT NULL,
+    key         TEXT        NOT NULL,
+    fingerprint TEXT        NOT NULL,
+    -- status_code is 0 while the first request is being processed
+    status_code INTEGER     NOT NULL DEFAULT 0,
+    body        BYTEA,
+    created_at  TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE outbox (
+    id             TEXT        PRIMARY KEY,
+    reservation_id TEXT        NOT NULL,
+    step           TEXT        NOT NULL,
+    attempt        INTEGER     NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,2 @@
+-- expired keys are found by creation time
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
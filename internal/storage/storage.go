@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/antnmxmv/booking-service/internal/booking"
+	"github.com/antnmxmv/booking-service/internal/config"
+	"github.com/antnmxmv/booking-service/internal/idempotency"
+)
+
+const (
+	InMemoryType = "inmemory"
+	PostgresType = "postgres"
+	BoltType     = "bolt"
+)
+
+// Backend is repository implementation which may need to connect somewhere before usage
+type Backend interface {
+	booking.Repository
+	idempotency.Store
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// Repository picks one of backends by config on start. idempotency keys are kept by the same backend.
+// config is not loaded while dependencies are being built,
+// so it must be started before any other module which uses repository
+type Repository struct {
+	booking.Repository
+	idempotency.Store
+	cnf      *config.Config
+	backends map[string]Backend
+	active   Backend
+}
+
+func NewRepository(cnf *config.Config, backends map[string]Backend) *Repository {
+	return &Repository{
+		cnf:      cnf,
+		backends: backends,
+	}
+}
+
+func (r *Repository) Start(ctx context.Context) error {
+	storageType := r.cnf.Storage.Type
+	if storageType == "" {
+		storageType = InMemoryType
+	}
+
+	backend, ok := r.backends[storageType]
+	if !ok {
+		return fmt.Errorf("storage type %q is not supported", storageType)
+	}
+
+	if err := backend.Start(ctx); err != nil {
+		return err
+	}
+
+	r.active = backend
+	r.Repository = backend
+	r.Store = backend
+
+	return nil
+}
+
+func (r *Repository) Stop(ctx context.Context) error {
+	if r.active == nil {
+		return nil
+	}
+	return r.active.Stop(ctx)
+}
@@ -0,0 +1,321 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	DefaultAckTimeout = time.Minute
+	// compactionThreshold is count of obsolete journal records which triggers journal rewriting
+	compactionThreshold = 1024
+)
+
+// PersistentOptions are read on start, so they may come from config loaded after queue creation
+type PersistentOptions struct {
+	// Path is journal file. queue is not persisted if it is empty
+	Path string
+	// AckTimeout is time after which delivered but not acknowledged message is delivered again
+	AckTimeout time.Duration
+}
+
+type journalOperation string
+
+const (
+	sendOperation   journalOperation = "send"
+	ackOperation    journalOperation = "ack"
+	cancelOperation journalOperation = "cancel"
+)
+
+type journalRecord[T any] struct {
+	Operation journalOperation `json:"op"`
+	Message   T                `json:"message"`
+	Due       time.Time        `json:"due,omitempty"`
+}
+
+// PersistentDelayedQueue is delayed queue with at-least-once delivery.
+// delivered message stays in queue until consumer acknowledges it, not acknowledged message
+// is delivered again after ack timeout. all pending messages are kept in append-only journal,
+// so they are delivered after restart. like DelayedQueue it schedules every message only once
+type PersistentDelayedQueue[T comparable] struct {
+	*scheduler[T]
+	optionsFn  func() PersistentOptions
+	ackTimeout time.Duration
+
+	journalPath string
+	journal     *os.File
+	// records is count of records in journal
+	records int
+}
+
+func NewPersistentDelayedQueue[T comparable](optionsFn func() PersistentOptions) *PersistentDelayedQueue[T] {
+	q := &PersistentDelayedQueue[T]{optionsFn: optionsFn}
+	q.scheduler = newScheduler[T](func(item *scheduled[T]) {
+		// delivered message waits for acknowledgement until redelivery
+		q.schedule.set(item.message, time.Now().Add(q.ackTimeout)).inFlight = true
+	})
+	return q
+}
+
+// SendMessage schedules message or moves already scheduled one.
+// delivered message is considered processed after it is sent again
+func (q *PersistentDelayedQueue[T]) SendMessage(message T, delay time.Duration) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	due := time.Now().Add(delay)
+
+	if err := q.writeJournal(journalRecord[T]{Operation: sendOperation, Message: message, Due: due}); err != nil {
+		return err
+	}
+
+	q.schedule.set(message, due)
+	q.wake()
+
+	return q.compactIfNeeded()
+}
+
+func (q *PersistentDelayedQueue[T]) Subscribe() <-chan T {
+	return q.queue
+}
+
+// Ack removes delivered message. message scheduled again after delivery stays in queue
+func (q *PersistentDelayedQueue[T]) Ack(message T) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	// message which is due but not marked as delivered yet is being received right now
+	if item, ok := q.schedule.get(message); !ok || !item.inFlight && item.due.After(time.Now()) {
+		return nil
+	}
+
+	return q.removeMessage(ackOperation, message)
+}
+
+// Cancel removes message from queue. it is not an error if message is not scheduled
+func (q *PersistentDelayedQueue[T]) Cancel(message T) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if _, ok := q.schedule.get(message); !ok {
+		return nil
+	}
+
+	return q.removeMessage(cancelOperation, message)
+}
+
+// Reschedule moves scheduled or delivered message to new time
+func (q *PersistentDelayedQueue[T]) Reschedule(message T, delay time.Duration) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if _, ok := q.schedule.get(message); !ok {
+		return ErrNotScheduled
+	}
+
+	due := time.Now().Add(delay)
+
+	if err := q.writeJournal(journalRecord[T]{Operation: sendOperation, Message: message, Due: due}); err != nil {
+		return err
+	}
+
+	q.schedule.set(message, due)
+	q.wake()
+
+	return q.compactIfNeeded()
+}
+
+// Len returns count of scheduled and not acknowledged messages
+func (q *PersistentDelayedQueue[T]) Len() int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.schedule.Len()
+}
+
+// Pending returns scheduled and not acknowledged messages ordered by delivery time
+func (q *PersistentDelayedQueue[T]) Pending() []Pending[T] {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.schedule.pending()
+}
+
+// Start loads pending messages from journal and starts delivery
+func (q *PersistentDelayedQueue[T]) Start(_ context.Context) error {
+	options := q.optionsFn()
+
+	q.ackTimeout = options.AckTimeout
+	if q.ackTimeout <= 0 {
+		q.ackTimeout = DefaultAckTimeout
+	}
+
+	if options.Path != "" {
+		if err := q.openJournal(options.Path); err != nil {
+			return fmt.Errorf("opening queue journal: %w", err)
+		}
+	}
+
+	q.start()
+
+	return nil
+}
+
+func (q *PersistentDelayedQueue[T]) Stop(_ context.Context) error {
+	q.stop()
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.journal == nil {
+		return nil
+	}
+	err := q.journal.Close()
+	q.journal = nil
+	return err
+}
+
+func (q *PersistentDelayedQueue[T]) removeMessage(op journalOperation, message T) error {
+	if err := q.writeJournal(journalRecord[T]{Operation: op, Message: message}); err != nil {
+		return err
+	}
+
+	q.schedule.remove(message)
+	q.wake()
+
+	return q.compactIfNeeded()
+}
+
+func (q *PersistentDelayedQueue[T]) openJournal(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if err := q.loadJournal(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	q.journal = file
+	q.journalPath = path
+
+	// there is no need to keep history of previous runs
+	return q.compact()
+}
+
+// loadJournal restores schedule. messages which were delivered before restart are pending again.
+// incomplete last record is left by crash in the middle of writing and is skipped
+func (q *PersistentDelayedQueue[T]) loadJournal(file *os.File) error {
+	reader := bufio.NewReader(file)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		record := journalRecord[T]{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+
+		switch record.Operation {
+		case sendOperation:
+			q.schedule.set(record.Message, record.Due)
+		case ackOperation, cancelOperation:
+			q.schedule.remove(record.Message)
+		default:
+			return fmt.Errorf("unknown queue journal operation %q", record.Operation)
+		}
+	}
+}
+
+func (q *PersistentDelayedQueue[T]) writeJournal(record journalRecord[T]) error {
+	if q.journal == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.journal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	q.records++
+
+	return q.journal.Sync()
+}
+
+func (q *PersistentDelayedQueue[T]) compactIfNeeded() error {
+	garbage := q.records - q.schedule.Len()
+	if garbage < compactionThreshold || garbage < q.schedule.Len() {
+		return nil
+	}
+	return q.compact()
+}
+
+// compact rewrites journal with only not acknowledged entries
+func (q *PersistentDelayedQueue[T]) compact() error {
+	if q.journal == nil {
+		return nil
+	}
+
+	path := q.journalPath
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, item := range q.schedule.items {
+		line, err := json.Marshal(journalRecord[T]{Operation: sendOperation, Message: item.message, Due: item.due})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	q.journal.Close()
+	q.journal = tmp
+	q.records = q.schedule.Len()
+
+	_, err = tmp.Seek(0, io.SeekEnd)
+	return err
+}
@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T, path string) *PersistentDelayedQueue[string] {
+	q := NewPersistentDelayedQueue[string](func() PersistentOptions {
+		return PersistentOptions{Path: path, AckTimeout: time.Millisecond * 50}
+	})
+	if err := q.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func receive(t *testing.T, q *PersistentDelayedQueue[string], timeout time.Duration) (string, bool) {
+	t.Helper()
+	select {
+	case msg := <-q.Subscribe():
+		return msg, true
+	case <-time.After(timeout):
+		return "", false
+	}
+}
+
+func TestPersistentDelayedQueue_Order(t *testing.T) {
+	q := newTestQueue(t, "")
+	defer q.Stop(context.Background())
+
+	_ = q.SendMessage("second", time.Millisecond*20)
+	_ = q.SendMessage("first", time.Millisecond*10)
+
+	for _, want := range []string{"first", "second"} {
+		msg, ok := receive(t, q, time.Second)
+		if !ok || msg != want {
+			t.Fatalf("received %q, want %q", msg, want)
+		}
+		_ = q.Ack(msg)
+	}
+}
+
+func TestPersistentDelayedQueue_Redelivery(t *testing.T) {
+	q := newTestQueue(t, "")
+	defer q.Stop(context.Background())
+
+	_ = q.SendMessage("msg", 0)
+
+	if msg, ok := receive(t, q, time.Second); !ok || msg != "msg" {
+		t.Fatalf("received %q, want %q", msg, "msg")
+	}
+
+	// not acknowledged message comes again after ack timeout
+	if msg, ok := receive(t, q, time.Second); !ok || msg != "msg" {
+		t.Fatalf("received %q, want redelivered %q", msg, "msg")
+	}
+	_ = q.Ack("msg")
+
+	if msg, ok := receive(t, q, time.Millisecond*200); ok {
+		t.Fatalf("received acknowledged message %q", msg)
+	}
+}
+
+func TestPersistentDelayedQueue_RescheduleDelivered(t *testing.T) {
+	q := newTestQueue(t, "")
+	defer q.Stop(context.Background())
+
+	_ = q.SendMessage("msg", 0)
+	if _, ok := receive(t, q, time.Second); !ok {
+		t.Fatal("message is not delivered")
+	}
+
+	// message sent again by consumer is not removed by acknowledgement of its delivered copy
+	_ = q.SendMessage("msg", time.Millisecond*100)
+	_ = q.Ack("msg")
+
+	if q.Len() != 1 {
+		t.Fatalf("Len() = %d, want 1", q.Len())
+	}
+	if msg, ok := receive(t, q, time.Second); !ok || msg != "msg" {
+		t.Fatalf("received %q, want %q", msg, "msg")
+	}
+}
+
+func TestPersistentDelayedQueue_Restart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.log")
+
+	q := newTestQueue(t, path)
+	_ = q.SendMessage("acked", 0)
+	_ = q.SendMessage("delivered", time.Millisecond*10)
+	_ = q.SendMessage("pending", time.Hour)
+
+	for i := 0; i < 2; i++ {
+		msg, ok := receive(t, q, time.Second)
+		if !ok {
+			t.Fatal("message is not delivered")
+		}
+		if msg == "acked" {
+			_ = q.Ack(msg)
+		}
+	}
+	if err := q.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	q = newTestQueue(t, path)
+	defer q.Stop(context.Background())
+
+	if msg, ok := receive(t, q, time.Second); !ok || msg != "delivered" {
+		t.Fatalf("received %q, want not acknowledged %q", msg, "delivered")
+	}
+	_ = q.Ack("delivered")
+
+	if pending := q.Pending(); len(pending) != 1 || pending[0].Message != "pending" {
+		t.Errorf("Pending() = %v, want only pending message", pending)
+	}
+}
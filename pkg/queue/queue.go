@@ -1,26 +1,90 @@
 package queue
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
-// DelayedQueue generic not persistent queue made with goroutines and time.Sleep
-type DelayedQueue[T any] struct {
-	queue chan T
+// DelayedQueue generic not persistent queue. messages are kept in min-heap by delivery time
+// and delivered by one goroutine. every message is scheduled only once, sending already
+// scheduled message moves it to new time
+type DelayedQueue[T comparable] struct {
+	*scheduler[T]
 }
 
-func NewDelayedQueue[T any]() *DelayedQueue[T] {
-	return &DelayedQueue[T]{
-		queue: make(chan T),
-	}
+func NewDelayedQueue[T comparable]() *DelayedQueue[T] {
+	q := &DelayedQueue[T]{}
+	q.scheduler = newScheduler[T](func(item *scheduled[T]) {
+		q.schedule.remove(item.message)
+	})
+	q.start()
+	return q
 }
 
 func (q *DelayedQueue[T]) SendMessage(message T, delay time.Duration) error {
-	go func() {
-		time.Sleep(delay)
-		q.queue <- message
-	}()
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.schedule.set(message, time.Now().Add(delay))
+	q.wake()
+
 	return nil
 }
 
 func (q *DelayedQueue[T]) Subscribe() <-chan T {
 	return q.queue
 }
+
+// Ack does nothing, messages are not redelivered
+func (q *DelayedQueue[T]) Ack(_ T) error {
+	return nil
+}
+
+// Cancel removes message from queue. it is not an error if message is not scheduled
+func (q *DelayedQueue[T]) Cancel(message T) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.schedule.remove(message) {
+		q.wake()
+	}
+
+	return nil
+}
+
+// Reschedule moves scheduled message to new time
+func (q *DelayedQueue[T]) Reschedule(message T, delay time.Duration) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if _, ok := q.schedule.get(message); !ok {
+		return ErrNotScheduled
+	}
+
+	q.schedule.set(message, time.Now().Add(delay))
+	q.wake()
+
+	return nil
+}
+
+// Len returns count of scheduled messages
+func (q *DelayedQueue[T]) Len() int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.schedule.Len()
+}
+
+// Pending returns scheduled messages ordered by delivery time
+func (q *DelayedQueue[T]) Pending() []Pending[T] {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.schedule.pending()
+}
+
+// Stop stops delivery goroutine
+func (q *DelayedQueue[T]) Stop(_ context.Context) error {
+	q.stop()
+	return nil
+}
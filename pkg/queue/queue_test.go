@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDelayedQueue_CancelAndReschedule(t *testing.T) {
+	q := NewDelayedQueue[string]()
+	defer q.Stop(context.Background())
+
+	_ = q.SendMessage("canceled", time.Millisecond*10)
+	_ = q.SendMessage("rescheduled", time.Hour)
+	_ = q.SendMessage("last", time.Millisecond*100)
+	// duplicate does not create second delivery
+	_ = q.SendMessage("last", time.Millisecond*50)
+
+	if q.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", q.Len())
+	}
+
+	_ = q.Cancel("canceled")
+	if err := q.Reschedule("rescheduled", time.Millisecond*20); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Reschedule("unknown", time.Millisecond); err != ErrNotScheduled {
+		t.Errorf("Reschedule() error = %v, want %v", err, ErrNotScheduled)
+	}
+
+	pending := q.Pending()
+	if len(pending) != 2 || pending[0].Message != "rescheduled" || pending[1].Message != "last" {
+		t.Fatalf("Pending() = %v", pending)
+	}
+
+	for _, want := range []string{"rescheduled", "last"} {
+		select {
+		case msg := <-q.Subscribe():
+			if msg != want {
+				t.Fatalf("received %q, want %q", msg, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q is not delivered", want)
+		}
+	}
+
+	select {
+	case msg := <-q.Subscribe():
+		t.Fatalf("received unexpected %q", msg)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	if q.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", q.Len())
+	}
+}
+
+func TestDelayedQueue_ManyMessages(t *testing.T) {
+	const count = 200_000
+
+	q := NewDelayedQueue[string]()
+	defer q.Stop(context.Background())
+
+	for i := 0; i < count; i++ {
+		_ = q.SendMessage(strconv.Itoa(i), time.Hour+time.Duration(i))
+	}
+	// half of holds are released before their time
+	for i := 0; i < count; i += 2 {
+		_ = q.Cancel(strconv.Itoa(i))
+	}
+	_ = q.Reschedule("1", 0)
+
+	select {
+	case msg := <-q.Subscribe():
+		if msg != "1" {
+			t.Fatalf("received %q, want %q", msg, "1")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message is not delivered")
+	}
+
+	// delivered message is removed from schedule right after subscriber received it
+	deadline := time.Now().Add(time.Second)
+	for q.Len() != count/2-1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if q.Len() != count/2-1 {
+		t.Errorf("Len() = %d, want %d", q.Len(), count/2-1)
+	}
+}
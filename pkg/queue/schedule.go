@@ -0,0 +1,210 @@
+package queue
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNotScheduled = errors.New("message is not scheduled")
+
+// Pending is scheduled message with its delivery time
+type Pending[T any] struct {
+	Message T
+	Due     time.Time
+}
+
+type scheduled[T comparable] struct {
+	message T
+	due     time.Time
+	// inFlight message is delivered and waits for acknowledgement until due
+	inFlight bool
+	// index is position in heap
+	index int
+}
+
+// schedule is min-heap of messages by due time with index by message,
+// so any message can be found, moved or removed in O(log n)
+type schedule[T comparable] struct {
+	items    []*scheduled[T]
+	messages map[T]*scheduled[T]
+}
+
+func newSchedule[T comparable]() schedule[T] {
+	return schedule[T]{messages: map[T]*scheduled[T]{}}
+}
+
+func (s *schedule[T]) Len() int { return len(s.items) }
+
+func (s *schedule[T]) Less(i, j int) bool { return s.items[i].due.Before(s.items[j].due) }
+
+func (s *schedule[T]) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+	s.items[i].index = i
+	s.items[j].index = j
+}
+
+func (s *schedule[T]) Push(x any) {
+	item := x.(*scheduled[T])
+	item.index = len(s.items)
+	s.items = append(s.items, item)
+}
+
+func (s *schedule[T]) Pop() any {
+	last := len(s.items) - 1
+	item := s.items[last]
+	s.items[last] = nil
+	s.items = s.items[:last]
+	return item
+}
+
+// set schedules message or moves already scheduled one
+func (s *schedule[T]) set(message T, due time.Time) *scheduled[T] {
+	if item, ok := s.messages[message]; ok {
+		item.due = due
+		item.inFlight = false
+		heap.Fix(s, item.index)
+		return item
+	}
+	item := &scheduled[T]{message: message, due: due}
+	heap.Push(s, item)
+	s.messages[message] = item
+	return item
+}
+
+func (s *schedule[T]) get(message T) (*scheduled[T], bool) {
+	item, ok := s.messages[message]
+	return item, ok
+}
+
+func (s *schedule[T]) remove(message T) bool {
+	item, ok := s.messages[message]
+	if !ok {
+		return false
+	}
+	heap.Remove(s, item.index)
+	delete(s.messages, message)
+	return true
+}
+
+func (s *schedule[T]) peek() (*scheduled[T], bool) {
+	if len(s.items) == 0 {
+		return nil, false
+	}
+	return s.items[0], true
+}
+
+// pending returns all messages ordered by due time
+func (s *schedule[T]) pending() []Pending[T] {
+	res := make([]Pending[T], len(s.items))
+	for i, item := range s.items {
+		res[i] = Pending[T]{Message: item.message, Due: item.due}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Due.Before(res[j].Due)
+	})
+	return res
+}
+
+// scheduler delivers messages of schedule at their due time by single goroutine,
+// so count of pending messages costs only memory
+type scheduler[T comparable] struct {
+	mux      sync.Mutex
+	schedule schedule[T]
+	// delivered is called under lock after message is received by subscriber
+	delivered func(item *scheduled[T])
+
+	queue  chan T
+	wakeCh chan struct{}
+	doneCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newScheduler[T comparable](delivered func(item *scheduled[T])) *scheduler[T] {
+	return &scheduler[T]{
+		schedule:  newSchedule[T](),
+		delivered: delivered,
+		queue:     make(chan T),
+		wakeCh:    make(chan struct{}, 1),
+		doneCh:    make(chan struct{}),
+	}
+}
+
+func (s *scheduler[T]) start() {
+	s.wg.Add(1)
+	go s.run()
+}
+
+func (s *scheduler[T]) stop() {
+	close(s.doneCh)
+	s.wg.Wait()
+}
+
+// wake makes delivery goroutine check the earliest message again. called under lock
+func (s *scheduler[T]) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler[T]) run() {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mux.Lock()
+		next, ok := s.schedule.peek()
+		var (
+			message T
+			wait    time.Duration
+		)
+		if ok {
+			message, wait = next.message, time.Until(next.due)
+		}
+		s.mux.Unlock()
+
+		if ok && wait <= 0 {
+			select {
+			case s.queue <- message:
+				s.mux.Lock()
+				// message could be canceled or moved while it was being delivered
+				if item, ok := s.schedule.get(message); ok && item == next && !item.due.After(time.Now()) {
+					s.delivered(item)
+				}
+				s.mux.Unlock()
+			case <-s.wakeCh:
+			case <-s.doneCh:
+				return
+			}
+			continue
+		}
+
+		var timerCh <-chan time.Time
+		if ok {
+			resetTimer(timer, wait)
+			timerCh = timer.C
+		}
+
+		select {
+		case <-timerCh:
+		case <-s.wakeCh:
+		case <-s.doneCh:
+			return
+		}
+	}
+}
+
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}